@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"io"
+	"os"
+
+	codimg "github.com/mmbros/test/coding/image"
+)
+
+// AnimationOptions are the options of the animation
+// of the work through a coding.
+type AnimationOptions struct {
+	// Zoom are the options of the zoom of the frames. If Zoom.CellH
+	// is 0, it is computed from Zoom.CellW and the aspect of the header.
+	Zoom codimg.ZoomOptions
+	// ByItem adds the items to the frames one by one,
+	// instead of a row at a time.
+	ByItem bool
+	// Step is the number of rows (or items) added by each frame.
+	Step int
+	// Delay is the delay between the frames, in 100ths of a second.
+	Delay int
+	// FinalDelay is the delay of the last frame, with the completed work.
+	FinalDelay int
+}
+
+// DefaultAnimationOptions are the default options of the animation.
+var DefaultAnimationOptions = AnimationOptions{
+	Zoom: codimg.ZoomOptions{
+		CellW:      8,
+		GridWidth:  1,
+		Background: color.White,
+	},
+	Step:       1,
+	Delay:      10,
+	FinalDelay: 300,
+}
+
+// Animation returns the animation of the work through the coding:
+// the first frame is the empty grid, and each frame adds the next rows
+// (or items) of the program, until the last one shows the image of the
+// coding. The frames use the exact palette of the coding.
+func (cod *Coding) Animation(opt *AnimationOptions) (*gif.GIF, error) {
+	if cod.pal.Len() >= 256 {
+		return nil, errors.New("animation: too many colors for a gif image")
+	}
+	step := opt.Step
+	if step < 1 {
+		step = 1
+	}
+	zopt := opt.Zoom
+	if zopt.CellH == 0 {
+		zopt.CellH = zopt.CellW / cod.hdr.Aspect.Ratio()
+		if zopt.CellH < 1 {
+			zopt.CellH = 1
+		}
+	}
+
+	full := cod.paletted()
+	nullIdx := uint8(len(full.Palette) - 1)
+	work := image.NewPaletted(full.Rect, full.Palette)
+	for j := range work.Pix {
+		work.Pix[j] = nullIdx
+	}
+
+	anim := &gif.GIF{}
+	// addFrame adds the frame of the work, drawing over the previous
+	// frame only the cells from the row y0 to the row y1 included.
+	addFrame := func(y0, y1, delay int) error {
+		m, l, err := codimg.ZoomWith(work, zopt)
+		if err != nil {
+			return err
+		}
+		frame, ok := m.(*image.Paletted)
+		if !ok {
+			return errors.New("animation: too many colors for a gif image")
+		}
+		if len(anim.Image) > 0 {
+			dx := work.Rect.Dx()
+			r := l.Cell(0, y0).Union(l.Cell(dx-1, y1))
+			frame = frame.SubImage(r).(*image.Paletted)
+		}
+		anim.Image = append(anim.Image, frame)
+		anim.Delay = append(anim.Delay, delay)
+		anim.Disposal = append(anim.Disposal, gif.DisposalNone)
+		return nil
+	}
+
+	if err := addFrame(0, 0, opt.Delay); err != nil {
+		return nil, err
+	}
+	p := NewProgress(cod)
+	for !p.Done() {
+		y0 := p.Row
+		if opt.ByItem {
+			p.Next(step)
+		} else {
+			row := p.Row + step
+			if row > len(cod.prog) {
+				row = len(cod.prog)
+			}
+			p.Seek(row)
+		}
+		y1 := p.Row
+		if p.Item == 0 && y1 > y0 {
+			// the last row is completed
+			y1--
+		}
+		// copy the completed cells from the full image
+		for y := y0; y <= y1 && y < len(cod.prog); y++ {
+			n := cod.prog[y].Len()
+			if y == p.Row {
+				n = 0
+				for _, item := range cod.prog[y][:p.Item] {
+					n += item.n
+				}
+			}
+			i := full.PixOffset(0, y)
+			copy(work.Pix[i:i+n], full.Pix[i:i+n])
+		}
+		delay := opt.Delay
+		if p.Done() {
+			delay = opt.FinalDelay
+		}
+		if err := addFrame(y0, y1, delay); err != nil {
+			return nil, err
+		}
+	}
+	return anim, nil
+}
+
+// EncodeGif writes to w the animation of the work through the coding.
+func (cod *Coding) EncodeGif(w io.Writer, opt *AnimationOptions) error {
+	anim, err := cod.Animation(opt)
+	if err != nil {
+		return err
+	}
+	return gif.EncodeAll(w, anim)
+}
+
+// SaveAsGif saves the animation of the work through the coding
+// in the gif format.
+func (cod *Coding) SaveAsGif(path string, opt *AnimationOptions) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = cod.EncodeGif(f, opt)
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	return err
+}
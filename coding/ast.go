@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// nodeKind identifies the kind of a line of a coding file.
+type nodeKind byte
+
+const (
+	nodeBlank   nodeKind = iota // empty or comment only line
+	nodeMarker                  // section marker
+	nodeHeader                  // header row
+	nodeLegend                  // legend row
+	nodeProgram                 // program row
+)
+
+// node is a line of a coding file.
+type node struct {
+	kind    nodeKind
+	section sectionEnum
+	key     string // header or legend key
+	row     int    // index of the first program row
+	nrows   int    // number of program rows of the statement
+	rows    string // the program rows of the statement, expanded
+	raw     string // the original line
+	text    string // the canonical content of the original line
+	comment string // the trailing comment, including the "//"
+}
+
+// syntaxTree is the line by line representation of a coding file.
+// It keeps the comments, the blank lines and the original notation
+// of the colors, so that the coding can be written back
+// changing only the lines whose content has changed.
+type syntaxTree struct {
+	nodes    []*node
+	explicit bool // the file uses the section markers
+}
+
+// add appends to the tree the physical line raw,
+// whose canonical content is text.
+func (t *syntaxTree) add(kind nodeKind, section sectionEnum, raw, text string) *node {
+	n := &node{
+		kind:    kind,
+		section: section,
+		raw:     raw,
+		text:    text,
+	}
+	if j := commentIndex(raw); j >= 0 {
+		n.comment = strings.TrimRight(raw[j:], " \t")
+	}
+	if kind == nodeMarker {
+		t.explicit = true
+	}
+	t.nodes = append(t.nodes, n)
+	return n
+}
+
+// printOptions are the options used to write a coding.
+type printOptions struct {
+	canonical bool // rewrite the unchanged rows in the canonical format
+	compact   bool // write the new program rows in the compact syntax
+}
+
+// lineWriter writes the lines of a coding.
+type lineWriter struct {
+	w         io.Writer
+	canonical bool
+}
+
+// write writes the content of the line of node n.
+// The original line is written if the content did not change,
+// unless the canonical format is requested.
+func (lw *lineWriter) write(n *node, content string) {
+	switch {
+	case n == nil:
+		fmt.Fprintln(lw.w, content)
+	case !lw.canonical && content == n.text:
+		fmt.Fprintln(lw.w, n.raw)
+	case content == "":
+		fmt.Fprintln(lw.w, n.comment)
+	case n.comment == "":
+		fmt.Fprintln(lw.w, content)
+	default:
+		fmt.Fprintln(lw.w, content, n.comment)
+	}
+}
+
+// fprint writes the coding to w following the layout of the tree.
+// Rows of the coding not present in the tree are written
+// after the last row of their section; rows of the tree
+// not present anymore in the coding are dropped.
+func (t *syntaxTree) fprint(w io.Writer, cod *Coding, opt printOptions) {
+	lw := &lineWriter{w: w, canonical: opt.canonical}
+
+	var hasHeader bool
+	// last[s] is the index of the node after which
+	// the missing rows of section s are written
+	last := map[sectionEnum]int{}
+	for j, n := range t.nodes {
+		if n.kind == nodeMarker && n.section == sectionHeader {
+			hasHeader = true
+		}
+		if n.kind != nodeBlank {
+			last[n.section] = j
+		}
+	}
+
+	done := map[string]bool{}
+	printed := 0
+
+	flushHeader := func() {
+		for _, k := range headerKeys {
+			if s := cod.hdr.row(k); s != "" && !done["h:"+k] {
+				done["h:"+k] = true
+				lw.write(nil, s)
+			}
+		}
+	}
+	flushLegend := func() {
+		for _, k := range cod.pal.i2k {
+			if !done["l:"+k] {
+				done["l:"+k] = true
+				lw.write(nil, cod.pal.row(k))
+			}
+		}
+	}
+	flushProgram := func() {
+		if opt.compact {
+			for _, s := range cod.prog.compact(printed) {
+				lw.write(nil, s)
+			}
+			printed = len(cod.prog)
+		}
+		for ; printed < len(cod.prog); printed++ {
+			lw.write(nil, cod.prog.row(printed))
+		}
+	}
+
+	// a header added to a file without it
+	addHeader := !hasHeader && !cod.hdr.IsZero()
+	if addHeader {
+		lw.write(nil, "[header]")
+		flushHeader()
+		lw.write(nil, "")
+	}
+	// markers needed by the sections of a file without them
+	needMarkers := addHeader && !t.explicit
+	marked := map[sectionEnum]bool{}
+
+	for j, n := range t.nodes {
+		if needMarkers && n.kind != nodeBlank && !marked[n.section] {
+			marked[n.section] = true
+			lw.write(nil, "["+sectionName(n.section)+"]")
+		}
+
+		switch n.kind {
+		case nodeBlank, nodeMarker:
+			lw.write(n, n.text)
+		case nodeHeader:
+			if s := cod.hdr.row(n.key); s != "" && !done["h:"+n.key] {
+				done["h:"+n.key] = true
+				lw.write(n, s)
+			}
+		case nodeLegend:
+			if cod.pal.HasKey(n.key) && !done["l:"+n.key] {
+				done["l:"+n.key] = true
+				lw.write(n, cod.pal.row(n.key))
+			}
+		case nodeProgram:
+			if n.row != printed {
+				break
+			}
+			if cod.prog.text(n.row, n.nrows) == n.rows {
+				// unchanged statement
+				lw.write(n, n.text)
+				printed += n.nrows
+				break
+			}
+			for j := 0; j < n.nrows && printed < len(cod.prog); j++ {
+				if j == 0 {
+					lw.write(n, cod.prog.row(printed))
+				} else {
+					lw.write(nil, cod.prog.row(printed))
+				}
+				printed++
+			}
+		}
+
+		if k, ok := last[n.section]; ok && k == j {
+			switch n.section {
+			case sectionHeader:
+				flushHeader()
+			case sectionLegend:
+				flushLegend()
+			case sectionProgram:
+				flushProgram()
+			}
+		}
+	}
+
+	// sections without rows in the tree
+	flushLegend()
+	flushProgram()
+}
+
+func sectionName(sec sectionEnum) string {
+	for name, s := range sectionNames {
+		if s == sec {
+			return name
+		}
+	}
+	return ""
+}
+
+// dropProgram removes the program rows from the tree,
+// so that the whole program is written again.
+func (t *syntaxTree) dropProgram() {
+	if t == nil {
+		return
+	}
+	nodes := t.nodes[:0]
+	for _, n := range t.nodes {
+		if n.kind != nodeProgram {
+			nodes = append(nodes, n)
+		}
+	}
+	t.nodes = nodes
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strconv"
+
+	codimg "github.com/mmbros/test/coding/image"
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// ChartOptions are the options of the chart of a coding.
+type ChartOptions struct {
+	// CellSize is the side of a cell, in pixels.
+	CellSize int
+	// Color fills the cells with their color.
+	Color bool
+	// Symbols draws the symbol of the color in the cells.
+	Symbols bool
+	// Aspect is the ratio between the width and the height
+	// of a cell, CellSize being the width. If zero,
+	// the aspect of the header of the coding is used.
+	Aspect codimg.Aspect
+	// PageCols and PageRows are the maximum number of cells
+	// of a page of the chart. Zero means no limit.
+	PageCols int
+	PageRows int
+	// Progress, if not nil, is highlighted on the chart.
+	Progress *Progress
+}
+
+// DefaultChartOptions are the default options of the chart.
+var DefaultChartOptions = ChartOptions{
+	CellSize: 20,
+	Color:    true,
+	Symbols:  true,
+	PageCols: 40,
+	PageRows: 60,
+}
+
+// chartSymbols are the symbols of the colors of the chart,
+// in the order of the palette: the most distinct ones first, then
+// the letters and the digits that can not be mistaken for others.
+// The colors after the last symbol use two symbols.
+const chartSymbols = "XO#+*=%@/\\<>^~$&?!SZHVNTLAKEYW" +
+	"BCDFGJMPQRU" + "abcdefghkmnpqrstuvwxyz" + "23456789"
+
+var (
+	chartFace      = basicfont.Face7x13
+	chartThinLine  = color.Gray{0xb0}
+	chartBoldLine  = color.Black
+	chartText      = color.Black
+	chartGridEvery = 10 // bold gridlines every chartGridEvery cells
+	chartPad       = 6  // padding around the labels, in pixels
+)
+
+// chartSymbol returns the symbol of the color of index idx.
+func chartSymbol(idx int) string {
+	n := len(chartSymbols)
+	switch {
+	case idx < n:
+		return chartSymbols[idx : idx+1]
+	case idx < n+n*n:
+		idx -= n
+		return chartSymbols[idx/n:idx/n+1] + chartSymbols[idx%n:idx%n+1]
+	}
+	return strconv.Itoa(idx)
+}
+
+// textWidth returns the width of the text, in pixels.
+func textWidth(s string) int {
+	return font.MeasureString(chartFace, s).Ceil()
+}
+
+// drawText draws the text with the top left corner in (x, y).
+func drawText(dst draw.Image, x, y int, s string, c color.Color) {
+	d := &font.Drawer{
+		Dst:  dst,
+		Src:  image.NewUniform(c),
+		Face: chartFace,
+		Dot:  fixed.P(x, y+chartFace.Ascent),
+	}
+	d.DrawString(s)
+}
+
+// drawTextIn draws the text centered in r, shrunk to fit r
+// keeping its proportions.
+func drawTextIn(dst draw.Image, r image.Rectangle, s string, c color.Color) {
+	w, h := textWidth(s), chartFace.Height
+	if w <= 0 || r.Empty() {
+		return
+	}
+	text := image.NewAlpha(image.Rect(0, 0, w, h))
+	d := &font.Drawer{
+		Dst:  text,
+		Src:  image.Opaque,
+		Face: chartFace,
+		Dot:  fixed.P(0, chartFace.Ascent),
+	}
+	d.DrawString(s)
+
+	scale := math.Min(float64(r.Dx())/float64(w), float64(r.Dy())/float64(h))
+	sw, sh := int(math.Max(1, float64(w)*scale)), int(math.Max(1, float64(h)*scale))
+	mask := image.NewAlpha(image.Rect(0, 0, sw, sh))
+	xdraw.ApproxBiLinear.Scale(mask, mask.Rect, text, text.Rect, draw.Src, nil)
+
+	min := image.Pt(r.Min.X+(r.Dx()-sw)/2, r.Min.Y+(r.Dy()-sh)/2)
+	draw.DrawMask(dst, mask.Rect.Add(min), image.NewUniform(c), image.Point{}, mask, image.Point{}, draw.Over)
+}
+
+// contrastColor returns black or white, the most readable over c.
+func contrastColor(c color.Color) color.Color {
+	r, g, b, a := c.RGBA()
+	if a == 0 {
+		return color.Black
+	}
+	// relative luminance, roughly
+	if 299*r+587*g+114*b > 1000*0x8000 {
+		return color.Black
+	}
+	return color.White
+}
+
+func fillRect(dst draw.Image, r image.Rectangle, c color.Color) {
+	draw.Draw(dst, r, image.NewUniform(c), image.Point{}, draw.Src)
+}
+
+// chart draws the charts of a coding.
+type chart struct {
+	cod    *Coding
+	opt    ChartOptions
+	cw, ch int     // size of a cell, in pixels
+	cells  [][]int // color index of each cell, -1 for the null color
+}
+
+func newChart(cod *Coding, opt *ChartOptions) *chart {
+	if opt == nil {
+		opt = &DefaultChartOptions
+	}
+	c := &chart{cod: cod, opt: *opt}
+	if c.opt.CellSize <= 0 {
+		c.opt.CellSize = DefaultChartOptions.CellSize
+	}
+	if c.opt.Aspect == (codimg.Aspect{}) {
+		c.opt.Aspect = cod.hdr.Aspect
+	}
+	c.cw, c.ch = c.opt.Aspect.CellSize(c.opt.CellSize)
+	dx, dy := cod.Size()
+	c.cells = make([][]int, dy)
+	for y := range c.cells {
+		c.cells[y] = make([]int, dx)
+	}
+	cod.draw(func(x, y, idx int) {
+		c.cells[y][x] = idx
+	})
+	return c
+}
+
+// legendEntries returns the text of the legend of each color.
+func (c *chart) legendEntries() []string {
+	var a []string
+	for _, k := range c.cod.pal.i2k {
+		a = append(a, c.cod.pal.row(k))
+	}
+	return a
+}
+
+// page returns the chart of the cells of the rectangle r,
+// with the row and column numbers, the title and the legend.
+func (c *chart) page(r image.Rectangle) image.Image {
+	cw, ch := c.cw, c.ch
+	lineH := chartFace.Height
+
+	// left margin with the row numbers, top margin with the title
+	// and the column numbers
+	left := textWidth(strconv.Itoa(len(c.cells))) + 2*chartPad
+	top := lineH + 2*chartPad
+	if c.cod.hdr.Title != "" {
+		top += lineH + chartPad
+	}
+	gridW, gridH := r.Dx()*cw, r.Dy()*ch
+
+	// legend, in columns as wide as the longest entry
+	entries := c.legendEntries()
+	var entryW int
+	for _, s := range entries {
+		if w := cw + chartPad + textWidth(s) + 2*chartPad; w > entryW {
+			entryW = w
+		}
+	}
+	width := left + gridW + chartPad
+	if width < left+entryW {
+		width = left + entryW
+	}
+	perRow := 1
+	if entryW > 0 && (width-left)/entryW > 1 {
+		perRow = (width - left) / entryW
+	}
+	entryH := ch + chartPad
+	if entryH < lineH+chartPad {
+		entryH = lineH + chartPad
+	}
+	legendRows := (len(entries) + perRow - 1) / perRow
+	height := top + gridH + 2*chartPad + legendRows*entryH + chartPad
+
+	m := image.NewRGBA(image.Rect(0, 0, width, height))
+	fillRect(m, m.Bounds(), color.White)
+
+	if c.cod.hdr.Title != "" {
+		drawText(m, left, chartPad, c.cod.hdr.Title, chartText)
+	}
+
+	// cells
+	pal := c.cod.pal.Palette()
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			idx := c.cells[y][x]
+			if idx < 0 {
+				continue
+			}
+			px, py := left+(x-r.Min.X)*cw, top+(y-r.Min.Y)*ch
+			c.drawCell(m, px, py, idx, pal[idx])
+		}
+	}
+
+	// thin gridlines, then bold ones over them
+	for bold := 0; bold < 2; bold++ {
+		for x := r.Min.X; x <= r.Max.X; x++ {
+			if isBold := x%chartGridEvery == 0 || x == r.Min.X || x == r.Max.X; isBold == (bold == 1) {
+				c.drawLine(m, left+(x-r.Min.X)*cw, top, 1, gridH+1, isBold)
+			}
+		}
+		for y := r.Min.Y; y <= r.Max.Y; y++ {
+			if isBold := y%chartGridEvery == 0 || y == r.Min.Y || y == r.Max.Y; isBold == (bold == 1) {
+				c.drawLine(m, left, top+(y-r.Min.Y)*ch, gridW+1, 1, isBold)
+			}
+		}
+	}
+
+	if c.opt.Progress != nil {
+		clip := image.Rect(left-2, top-2, left+gridW+2, top+gridH+2)
+		cell := func(x, y int) image.Rectangle {
+			x0, y0 := left+(x-r.Min.X)*cw, top+(y-r.Min.Y)*ch
+			return image.Rect(x0, y0, x0+cw, y0+ch)
+		}
+		c.opt.Progress.highlight(m, cell, clip)
+	}
+
+	// row numbers, as in the program, and column numbers
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		s := strconv.Itoa(y + 1)
+		drawText(m, left-chartPad-textWidth(s), top+(y-r.Min.Y)*ch+(ch-lineH)/2, s, chartText)
+	}
+	for x := r.Min.X; x < r.Max.X; x++ {
+		if (x+1)%chartGridEvery != 0 && x != r.Min.X {
+			continue
+		}
+		s := strconv.Itoa(x + 1)
+		drawText(m, left+(x-r.Min.X)*cw+(cw-textWidth(s))/2, top-lineH-chartPad, s, chartText)
+	}
+
+	// legend
+	ly := top + gridH + 2*chartPad
+	for j, s := range entries {
+		px := left + (j%perRow)*entryW
+		py := ly + (j/perRow)*entryH
+		c.drawCell(m, px, py, j, pal[j])
+		c.drawLine(m, px, py, cw+1, 1, false)
+		c.drawLine(m, px, py+ch, cw+1, 1, false)
+		c.drawLine(m, px, py, 1, ch+1, false)
+		c.drawLine(m, px+cw, py, 1, ch+1, false)
+		drawText(m, px+cw+chartPad, py+(ch-lineH)/2, s, chartText)
+	}
+	return m
+}
+
+// drawCell draws the cell with the top left corner in (x, y).
+func (c *chart) drawCell(m draw.Image, x, y, idx int, col color.Color) {
+	cw, ch := c.cw, c.ch
+	fg := color.Color(color.Black)
+	if c.opt.Color {
+		fillRect(m, image.Rect(x, y, x+cw, y+ch), col)
+		fg = contrastColor(col)
+	}
+	if c.opt.Symbols {
+		s := chartSymbol(idx)
+		if w := textWidth(s); w > cw-2 || chartFace.Height > ch {
+			// the symbol is shrunk to fit the cell
+			drawTextIn(m, image.Rect(x+1, y+1, x+cw-1, y+ch-1), s, fg)
+		} else {
+			drawText(m, x+(cw-w+1)/2, y+(ch-chartFace.Height)/2+1, s, fg)
+		}
+	}
+}
+
+// drawLine draws an horizontal or vertical gridline
+// with the top left corner in (x, y).
+func (c *chart) drawLine(m draw.Image, x, y, w, h int, bold bool) {
+	if !bold {
+		fillRect(m, image.Rect(x, y, x+w, y+h), chartThinLine)
+		return
+	}
+	// bold lines are 2 pixels wide, centered on the gridline
+	if w == 1 {
+		fillRect(m, image.Rect(x-1, y-1, x+1, y+h), chartBoldLine)
+	} else {
+		fillRect(m, image.Rect(x-1, y-1, x+w, y+1), chartBoldLine)
+	}
+}
+
+// pages returns the rectangles of cells of the pages of the chart,
+// from the top left one, by rows.
+func (c *chart) pages() []image.Rectangle {
+	dx, dy := c.cod.Size()
+	pw, ph := c.opt.PageCols, c.opt.PageRows
+	if pw <= 0 {
+		pw = dx
+	}
+	if ph <= 0 {
+		ph = dy
+	}
+	var rects []image.Rectangle
+	for y := 0; y < dy; y += ph {
+		for x := 0; x < dx; x += pw {
+			rects = append(rects, image.Rect(x, y, x+pw, y+ph).Intersect(image.Rect(0, 0, dx, dy)))
+		}
+	}
+	return rects
+}
+
+// Chart returns the chart of the whole coding in a single image:
+// a grid with the symbols of the colors, the row and column numbers
+// and the legend. If opt is nil, DefaultChartOptions is used.
+func (cod *Coding) Chart(opt *ChartOptions) image.Image {
+	c := newChart(cod, opt)
+	dx, dy := cod.Size()
+	return c.page(image.Rect(0, 0, dx, dy))
+}
+
+// ChartPages returns the chart of the coding split in pages
+// of at most opt.PageCols x opt.PageRows cells, each one
+// with its own legend. If opt is nil, DefaultChartOptions is used.
+func (cod *Coding) ChartPages(opt *ChartOptions) []image.Image {
+	c := newChart(cod, opt)
+	var pages []image.Image
+	for _, r := range c.pages() {
+		pages = append(pages, c.page(r))
+	}
+	return pages
+}
+
+// SaveChartAsPng saves the chart of the whole coding as a png image.
+func (cod *Coding) SaveChartAsPng(path string, opt *ChartOptions) error {
+	return codimg.SaveAsPng(cod.Chart(opt), path)
+}
+
+// SaveChartAsPdf saves the chart of the coding as a PDF document,
+// with a page for each page of the chart.
+func (cod *Coding) SaveChartAsPdf(path string, opt *ChartOptions) error {
+	return codimg.SaveAsPdf(cod.ChartPages(opt), path)
+}
@@ -0,0 +1,535 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	codimg "github.com/mmbros/test/coding/image"
+)
+
+// command represents a subcommand of the coding program.
+type command struct {
+	name  string
+	short string
+	run   func(args []string) error
+}
+
+var commands = []*command{
+	{"encode", "convert an image to a coding file", runEncode},
+	{"render", "render a coding file as a png or svg image", runRender},
+	{"animate", "render the work through a coding as an animated gif", runAnimate},
+	{"palettes", "compare the palette extractors on an image", runPalettes},
+	{"chart", "render a coding file as a printable chart", runChart},
+	{"fmt", "rewrite a coding file in the canonical format", runFmt},
+	{"info", "print informations about a coding file", runInfo},
+	{"progress", "show and update the progress of the work", runProgress},
+	{"stats", "print the statistics and the yarn estimate of a coding", runStats},
+}
+
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: coding <command> [flags]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", cmd.name, cmd.short)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun 'coding <command> -h' for the flags of a command.\n")
+}
+
+// newFlagSet returns the flag set of the named command.
+func newFlagSet(name string) *flag.FlagSet {
+	return flag.NewFlagSet("coding "+name, flag.ExitOnError)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// createOutput creates the file at path.
+// If path is empty or "-", the standard output is returned.
+func createOutput(path string) (io.WriteCloser, error) {
+	if path == "" || path == "-" {
+		return nopWriteCloser{os.Stdout}, nil
+	}
+	return os.Create(path)
+}
+
+// openInput opens the file at path.
+// If path is "-", the standard input is returned.
+func openInput(path string) (io.ReadCloser, error) {
+	if path == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+	return os.Open(path)
+}
+
+// readCoding reads the coding file at path.
+// If allErrors is true, all the errors of the file are reported.
+func readCoding(path string, allErrors bool) (*Coding, error) {
+	if path == "" {
+		return nil, errors.New("missing input coding file")
+	}
+	r, err := openInput(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	dec := NewDecoder(r)
+	dec.Filename = path
+	dec.AllErrors = allErrors
+
+	cod := NewCoding()
+	if err := dec.Decode(cod); err != nil {
+		return nil, err
+	}
+	return cod, nil
+}
+
+// writeCoding writes the coding to the file at path
+// using the given encoder options.
+func writeCoding(cod *Coding, path string, opt Encoder) error {
+	w, err := createOutput(path)
+	if err != nil {
+		return err
+	}
+	enc := NewEncoder(w)
+	enc.Canonical = opt.Canonical
+	enc.Compact = opt.Compact
+	err = enc.Encode(cod)
+	if e := w.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+// palettedFlags defines in fs the flags of the conversion of an image
+// to a paletted image. The returned function, called after parsing
+// the flags, returns the options of the conversion.
+func palettedFlags(fs *flag.FlagSet) func() (*codimg.PalettedOptions, error) {
+	var opt codimg.PalettedOptions
+	fs.IntVar(&opt.Width, "width", 41, "number of columns of the coding")
+	fs.IntVar(&opt.Height, "height", 0, "number of rows of the coding\n(0 = keep the proportions of the image)")
+	aspect := fs.String("aspect", "1:1", "ratio width:height of a cell, like 5:4 for knit stitches")
+	fs.IntVar(&opt.NumColors, "colors", 8, "maximum number of colors")
+	sampler := fs.String("sampler", "box", "color of a cell: "+strings.Join(codimg.SamplerNames, ", "))
+	outlines := fs.Bool("outlines", false, "keep the thin outlines of the image")
+	fs.BoolVar(&opt.DetectGrid, "grid", false, "detect the cells of an upscaled pixel art image,\nignoring -width, -height and -sampler")
+	fs.BoolVar(&opt.Perceptual, "perceptual", false, "maximize the perceptual distance between the colors")
+	palette := fs.String("palette", "vibrant", "palette extractor: "+strings.Join(codimg.ExtractorNames, ", "))
+	fixed := fs.String("fixed", "", "use the fixed palette of the colors separated by \";\"")
+	dither := fs.String("dither", "none", "dithering: none, floyd-steinberg, atkinson,\nbayer2, bayer4 or bayer8")
+	catalog := fs.String("catalog", "", "use only the colors of the catalog file (.csv or .json)")
+
+	return func() (*codimg.PalettedOptions, error) {
+		if opt.NumColors < 1 {
+			return nil, fmt.Errorf("invalid number of colors %d", opt.NumColors)
+		}
+		var err error
+		if opt.Aspect, err = codimg.ParseAspect(*aspect); err != nil {
+			return nil, err
+		}
+		if opt.Sampler, err = codimg.NewSampler(*sampler); err != nil {
+			return nil, err
+		}
+		if *outlines {
+			opt.Outlines = &codimg.DefaultOutlineOptions
+		}
+		if opt.Dither, err = codimg.ParseDither(*dither); err != nil {
+			return nil, err
+		}
+		if *fixed != "" {
+			opt.Extractor, err = codimg.ParseFixedPalette(*fixed)
+		} else {
+			opt.Extractor, err = codimg.NewExtractor(*palette)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if *catalog != "" {
+			if opt.Catalog, err = codimg.LoadCatalog(*catalog); err != nil {
+				return nil, err
+			}
+		}
+		return &opt, nil
+	}
+}
+
+func runEncode(args []string) error {
+	fs := newFlagSet("encode")
+	in := fs.String("in", "", "input image file (\"-\" for stdin): gif, jpeg, png, bmp, tiff or webp")
+	out := fs.String("out", "", "output coding file (default stdout)")
+	options := palettedFlags(fs)
+	cvd := fs.Float64("cvd", 0, "warn about colors closer than this CIEDE2000 distance\nfor color blind people (0 = no check)")
+	title := fs.String("title", "", "title of the coding")
+	author := fs.String("author", "", "author of the coding")
+	stitch := fs.String("stitch", "", "kind of stitch or bead")
+	compact := fs.Bool("compact", false, "write the program in the compact syntax\n(always used for symmetric images)")
+	fs.Parse(args)
+
+	if *in == "" {
+		return errors.New("encode: missing input image file")
+	}
+	opt, err := options()
+	if err != nil {
+		return fmt.Errorf("encode: %w", err)
+	}
+	r, err := openInput(*in)
+	if err != nil {
+		return err
+	}
+	m, _, err := codimg.Decode(r)
+	r.Close()
+	if err != nil {
+		return fmt.Errorf("encode: %w", err)
+	}
+	// more than 256 colors need a full color image
+	mm, err := codimg.ToImage(m, opt)
+	if err != nil {
+		return err
+	}
+	cod, err := image2coding(mm)
+	if err != nil {
+		return err
+	}
+	if opt.Catalog != nil {
+		setCatalogReferences(cod, opt.Catalog)
+	}
+	for _, p := range cod.pal.confusablePairs(*cvd) {
+		log.Printf("warning: %s", p)
+	}
+	cod.hdr = Header{
+		Title:  *title,
+		Author: *author,
+		Width:  mm.Bounds().Dx(),
+		Height: mm.Bounds().Dy(),
+		Source: *in,
+		Stitch: *stitch,
+		// the mirror shorthand is written by the compact syntax
+		Symmetry: cod.prog.Symmetry(),
+	}
+	if !opt.Aspect.IsSquare() {
+		cod.hdr.Aspect = opt.Aspect
+	}
+	return writeCoding(cod, *out, Encoder{Compact: *compact || cod.hdr.Symmetry != ""})
+}
+
+func runRender(args []string) error {
+	fs := newFlagSet("render")
+	in := fs.String("in", "", "input coding file (\"-\" for stdin)")
+	out := fs.String("out", "", "output image file, .png or .svg\n(only -zoom and -aspect apply to svg images)")
+	var opt codimg.ZoomOptions
+	fs.Float64Var(&opt.CellW, "zoom", 6, "width of a cell, in pixels (can be fractional)")
+	aspect := fs.String("aspect", "", "ratio width:height of a cell (default from the header)")
+	fs.IntVar(&opt.GridWidth, "gridline", 0, "width of the gridlines between the cells, in pixels")
+	fs.IntVar(&opt.MajorEvery, "major", 0, "draw a major gridline every n cells (0 = none)")
+	fs.IntVar(&opt.Border, "border", 0, "width of the border, in pixels")
+	bg := fs.String("bg", "", "color of the background of the transparent cells")
+	fs.IntVar(&opt.Checkerboard, "checker", 0, "side of the squares of the checkerboard\ndrawn under the transparent cells (0 = none)")
+	progress := fs.Bool("progress", false, "highlight the current row of the progress file")
+	fs.Parse(args)
+
+	if *in == "" || *out == "" {
+		return errors.New("render: missing input or output file")
+	}
+	if *progress && *in == "-" {
+		return errors.New("render: -progress needs an input coding file, not the standard input")
+	}
+	if opt.CellW < 1 {
+		return fmt.Errorf("render: invalid zoom factor %g", opt.CellW)
+	}
+	if *bg != "" {
+		var err error
+		if opt.Background, err = codimg.ParseColor(*bg); err != nil {
+			return fmt.Errorf("render: %w", err)
+		}
+	}
+	var a codimg.Aspect
+	if *aspect != "" {
+		var err error
+		if a, err = codimg.ParseAspect(*aspect); err != nil {
+			return fmt.Errorf("render: %w", err)
+		}
+	}
+	if strings.ToLower(filepath.Ext(*out)) == ".svg" {
+		// the svg image has only the cells
+		var err error
+		fs.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "in", "out", "zoom", "aspect":
+			default:
+				if err == nil {
+					err = fmt.Errorf("render: flag -%s not supported for svg images", f.Name)
+				}
+			}
+		})
+		if err != nil {
+			return err
+		}
+		cod, err := readCoding(*in, false)
+		if err != nil {
+			return err
+		}
+		return cod.SaveAsSvg(*out, opt.CellW, a)
+	}
+	return txt2png(*in, *out, opt, a, *progress)
+}
+
+func runAnimate(args []string) error {
+	opt := DefaultAnimationOptions
+	fs := newFlagSet("animate")
+	in := fs.String("in", "", "input coding file (\"-\" for stdin)")
+	out := fs.String("out", "", "output gif file")
+	fs.Float64Var(&opt.Zoom.CellW, "zoom", opt.Zoom.CellW, "width of a cell, in pixels")
+	fs.IntVar(&opt.Zoom.GridWidth, "gridline", opt.Zoom.GridWidth, "width of the gridlines between the cells, in pixels")
+	fs.IntVar(&opt.Zoom.MajorEvery, "major", opt.Zoom.MajorEvery, "draw a major gridline every n cells (0 = none)")
+	fs.BoolVar(&opt.ByItem, "item", opt.ByItem, "add the items one by one, instead of the rows")
+	fs.IntVar(&opt.Step, "step", opt.Step, "rows (or items) added by each frame")
+	fs.IntVar(&opt.Delay, "delay", opt.Delay, "delay between the frames, in 100ths of a second")
+	fs.IntVar(&opt.FinalDelay, "final", opt.FinalDelay, "delay of the last frame, in 100ths of a second")
+	fs.Parse(args)
+
+	if *out == "" {
+		return errors.New("animate: missing output file")
+	}
+	if opt.Zoom.CellW < 1 {
+		return fmt.Errorf("animate: invalid zoom factor %g", opt.Zoom.CellW)
+	}
+	cod, err := readCoding(*in, false)
+	if err != nil {
+		return err
+	}
+	return cod.SaveAsGif(*out, &opt)
+}
+
+func runChart(args []string) error {
+	opt := DefaultChartOptions
+	fs := newFlagSet("chart")
+	in := fs.String("in", "", "input coding file (\"-\" for stdin)")
+	out := fs.String("out", "", "output chart file, .png or .pdf")
+	fs.IntVar(&opt.CellSize, "cell", opt.CellSize, "width of a cell in pixels")
+	aspect := fs.String("aspect", "", "ratio width:height of a cell (default from the header)")
+	fs.BoolVar(&opt.Color, "color", opt.Color, "fill the cells with their color")
+	fs.BoolVar(&opt.Symbols, "symbols", opt.Symbols, "draw the symbols of the colors")
+	fs.IntVar(&opt.PageCols, "pagecols", opt.PageCols, "columns of a pdf page (0 = no limit)")
+	fs.IntVar(&opt.PageRows, "pagerows", opt.PageRows, "rows of a pdf page (0 = no limit)")
+	progress := fs.Bool("progress", false, "highlight the current row of the progress file")
+	fs.Parse(args)
+
+	if *aspect != "" {
+		var err error
+		if opt.Aspect, err = codimg.ParseAspect(*aspect); err != nil {
+			return fmt.Errorf("chart: %w", err)
+		}
+	}
+	if *out == "" {
+		return errors.New("chart: missing output file")
+	}
+	if *progress && (*in == "" || *in == "-") {
+		return errors.New("chart: -progress needs an input coding file, not the standard input")
+	}
+	cod, err := readCoding(*in, false)
+	if err != nil {
+		return err
+	}
+	if dx, dy := cod.Size(); dx == 0 || dy == 0 {
+		return errors.New("chart: empty coding")
+	}
+	if *progress {
+		if opt.Progress, err = LoadProgress(*in+ProgressExt, cod); err != nil {
+			return err
+		}
+	}
+	switch strings.ToLower(filepath.Ext(*out)) {
+	case ".png":
+		return cod.SaveChartAsPng(*out, &opt)
+	case ".pdf":
+		return cod.SaveChartAsPdf(*out, &opt)
+	}
+	return fmt.Errorf("chart: unknown format of the output file %q", *out)
+}
+
+func runFmt(args []string) error {
+	fs := newFlagSet("fmt")
+	in := fs.String("in", "", "input coding file (\"-\" for stdin)")
+	allErrors := fs.Bool("e", false, "report all errors")
+	out := fs.String("out", "", "output coding file (default stdout)")
+	compact := fs.Bool("compact", false, "rewrite the program in the compact syntax")
+	fs.Parse(args)
+
+	cod, err := readCoding(*in, *allErrors)
+	if err != nil {
+		return err
+	}
+	if *compact {
+		// forget the layout of the program rows
+		cod.tree.dropProgram()
+	}
+	return writeCoding(cod, *out, Encoder{Canonical: true, Compact: *compact})
+}
+
+func runInfo(args []string) error {
+	fs := newFlagSet("info")
+	in := fs.String("in", "", "input coding file (\"-\" for stdin)")
+	allErrors := fs.Bool("e", false, "report all errors")
+	cvd := fs.Float64("cvd", 10, "report colors closer than this CIEDE2000 distance\nfor color blind people (0 = no check)")
+	fs.Parse(args)
+
+	cod, err := readCoding(*in, *allErrors)
+	if err != nil {
+		return err
+	}
+	dx, dy := cod.Size()
+	fmt.Printf("file:   %s\n", *in)
+	fmt.Printf("size:   %d x %d\n", dx, dy)
+	fmt.Printf("colors: %d\n", cod.pal.Len())
+	if sym := cod.prog.Symmetry(); sym != "" {
+		fmt.Printf("symmetry: %s\n", sym)
+	}
+	fmt.Println()
+	if !cod.hdr.IsZero() {
+		cod.hdr.Fprint(os.Stdout)
+		fmt.Println()
+	}
+	cod.pal.Print()
+
+	if pairs := cod.pal.confusablePairs(*cvd); len(pairs) > 0 {
+		fmt.Printf("\nconfusable colors:\n")
+		for _, p := range pairs {
+			fmt.Printf("  %s\n", p)
+		}
+	}
+	return nil
+}
+
+func runProgress(args []string) error {
+	fs := newFlagSet("progress")
+	in := fs.String("in", "", "input coding file")
+	next := fs.Int("next", 0, "complete the next n items")
+	prev := fs.Int("prev", 0, "go back by n items")
+	row := fs.Int("row", 0, "go to the first item of the row")
+	reset := fs.Bool("reset", false, "restart from the first item")
+	fs.Parse(args)
+
+	if *in == "" || *in == "-" {
+		return errors.New("progress: missing input coding file")
+	}
+	cod, err := readCoding(*in, false)
+	if err != nil {
+		return err
+	}
+	path := *in + ProgressExt
+	p, err := LoadProgress(path, cod)
+	if err != nil {
+		return err
+	}
+
+	changed := *reset || *row > 0 || *next > 0 || *prev > 0
+	switch {
+	case *reset:
+		p = NewProgress(cod)
+	case *row > 0:
+		if err := p.Seek(*row - 1); err != nil {
+			return fmt.Errorf("progress: %w", err)
+		}
+	}
+	p.Prev(*prev)
+	p.Next(*next)
+	if changed {
+		if err := p.SaveAs(path); err != nil {
+			return err
+		}
+	}
+
+	if p.Done() {
+		fmt.Printf("row:    done (%d rows)\n", len(cod.prog))
+	} else {
+		r := cod.prog[p.Row]
+		fmt.Printf("row:    %d of %d\n", p.Row+1, len(cod.prog))
+		fmt.Printf("item:   %d of %d (%s)\n", p.Item+1, len(r), p.Current())
+	}
+	done, todo := p.Completed(), p.Remaining()
+	fmt.Printf("\n%-12s %8s %8s\n", "color", "done", "todo")
+	for _, k := range cod.pal.i2k {
+		fmt.Printf("%-12s %8d %8d\n", k, done[k], todo[k])
+	}
+	return nil
+}
+
+func runStats(args []string) error {
+	opt := DefaultYarnOptions
+	fs := newFlagSet("stats")
+	in := fs.String("in", "", "input coding file (\"-\" for stdin)")
+	fs.Float64Var(&opt.StitchSize, "stitch", opt.StitchSize, "side of a stitch in cm")
+	fs.Float64Var(&opt.YarnFactor, "factor", opt.YarnFactor, "yarn used by a stitch, in stitch sides")
+	fs.Float64Var(&opt.SkeinLength, "skein", opt.SkeinLength, "length of a skein in meters")
+	fs.Parse(args)
+
+	if opt.StitchSize <= 0 || opt.YarnFactor <= 0 || opt.SkeinLength <= 0 {
+		return errors.New("stats: the stitch size, the yarn factor and the skein length must be positive")
+	}
+	cod, err := readCoding(*in, false)
+	if err != nil {
+		return err
+	}
+	cod.Stats(&opt).Fprint(os.Stdout)
+	return nil
+}
+
+func runPalettes(args []string) error {
+	fs := newFlagSet("palettes")
+	in := fs.String("in", "", "input image file")
+	out := fs.String("out", "", "output png file")
+	zoom := fs.Int("zoom", 6, "zoom factor")
+	options := palettedFlags(fs)
+	fs.Parse(args)
+
+	if *in == "" || *out == "" {
+		return errors.New("palettes: missing input or output file")
+	}
+	if *zoom < 1 {
+		return fmt.Errorf("palettes: invalid zoom factor %d", *zoom)
+	}
+	opt, err := options()
+	if err != nil {
+		return fmt.Errorf("palettes: %w", err)
+	}
+	if opt.NumColors > 256 {
+		return fmt.Errorf("palettes: %d colors, more than 256", opt.NumColors)
+	}
+
+	// the extractors, and the fixed palette if any
+	names := append([]string{}, codimg.ExtractorNames...)
+	if _, ok := opt.Extractor.(codimg.FixedPalette); ok {
+		names = append(names, "fixed")
+	}
+	var images []*image.Paletted
+	for _, name := range names {
+		o := *opt
+		if name != "fixed" {
+			o.Extractor, _ = codimg.NewExtractor(name)
+		}
+		m, err := codimg.LoadPaletted(*in, &o)
+		if err != nil {
+			return fmt.Errorf("palettes: %s: %w", name, err)
+		}
+		images = append(images, m)
+	}
+	return codimg.SaveAsPng(comparePalettes(names, images, *zoom), *out)
+}
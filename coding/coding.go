@@ -9,7 +9,6 @@ import (
 	"io"
 	"os"
 	"regexp"
-	"strconv"
 	"strings"
 
 	codimg "github.com/mmbros/test/coding/image"
@@ -17,8 +16,11 @@ import (
 
 // Coding represents the coding informations for drawing a paletted image.
 type Coding struct {
+	hdr  Header
 	pal  *Palette
 	prog Program
+	// layout of the coding file the coding was read from, if any
+	tree *syntaxTree
 }
 
 type sectionEnum byte
@@ -26,12 +28,23 @@ type sectionEnum byte
 const (
 	sectionLegend sectionEnum = iota
 	sectionProgram
-	sectionEnd
+	sectionHeader
 )
 
+// sectionNames maps the names of the section markers
+// (as in "[legend]") to the sections.
+var sectionNames = map[string]sectionEnum{
+	"header":  sectionHeader,
+	"legend":  sectionLegend,
+	"program": sectionProgram,
+}
+
 var (
-	errInvalidLegendRow  = errors.New("Invalid legend row")
-	errInvalidProgramRow = errors.New("Invalid program row")
+	errInvalidLegendRow = errors.New("Invalid legend row")
+
+	reColorName     = regexp.MustCompile(`^[[:alpha:]]\w*$`)
+	reCatalogRef    = regexp.MustCompile(`\s\(\s*([^\s()]+\s+[^\s()]+)\s*\)$`)
+	reSectionMarker = regexp.MustCompile(`^\[\s*(\w+)\s*\]$`)
 )
 
 // NewCoding returns a new coding object.
@@ -52,133 +65,353 @@ func (cod *Coding) Read(path string) error {
 	}
 	defer inFile.Close()
 
-	scanner := bufio.NewScanner(inFile)
+	dec := NewDecoder(inFile)
+	dec.Filename = path
+	return dec.Decode(cod)
+}
+
+// A Decoder reads and decodes a coding from an input stream.
+type Decoder struct {
+	r io.Reader
+
+	// Filename is the name of the input reported in the errors.
+	Filename string
+	// AllErrors reports all the errors found in the input,
+	// instead of stopping at the first one.
+	AllErrors bool
+}
+
+// NewDecoder returns a new decoder that reads from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: r}
+}
+
+// srcLine is a line of the coding file,
+// without comments and surrounding spaces.
+type srcLine struct {
+	text string // content of the line
+	num  int    // line number, 1-based
+	off  int    // byte offset of text in the physical line
+}
+
+// newSrcLine returns the srcLine of the physical line s.
+func newSrcLine(s string, num int) srcLine {
+	// remove comments
+	if j := commentIndex(s); j >= 0 {
+		s = s[0:j]
+	}
+	s = strings.TrimRight(s, " \t")
+	text := strings.TrimLeft(s, " \t")
+	return srcLine{text, num, len(s) - len(text)}
+}
+
+// commentIndex returns the index of the comment in s, or -1.
+// A comment starts with "//" at the beginning of the line
+// or after a space, so that values like URLs are not truncated.
+func commentIndex(s string) int {
+	for j := 0; ; {
+		k := strings.Index(s[j:], "//")
+		if k < 0 {
+			return -1
+		}
+		j += k
+		if j == 0 || s[j-1] == ' ' || s[j-1] == '\t' {
+			return j
+		}
+		j += 2
+	}
+}
+
+// slice returns the part of the line starting at byte offset i,
+// without the surrounding spaces.
+func (l srcLine) slice(i int) srcLine {
+	s := strings.TrimRight(l.text[i:], " \t")
+	text := strings.TrimLeft(s, " \t")
+	return srcLine{text, l.num, l.off + i + len(s) - len(text)}
+}
+
+// error returns a *ParseError of the given kind for the token
+// found at byte offset i of the line.
+func (l srcLine) error(kind ErrorKind, i int, token string, err error) *ParseError {
+	e := &ParseError{Kind: kind, Token: token, Err: err}
+	if l.num > 0 {
+		e.Line = l.num
+		e.Column = l.off + i + 1
+	}
+	return e
+}
+
+// maxLineLength is the maximum length in bytes of a line of a coding
+// file: it is enough for a program row of maxRowLength items.
+const maxLineLength = 1 << 20
+
+// Decode reads the coding from its input and stores it in cod.
+// On error, cod is left unchanged.
+//
+// The coding is made of an optional header, the legend and the program.
+// Each section can be introduced by a marker ("[header]", "[legend]",
+// "[program]"). Without markers, the legend ends at the first row
+// that is not a legend row.
+// The returned error is a *ParseError, or an ErrorList
+// if AllErrors is set and more than one error is found.
+func (dec *Decoder) Decode(cod *Coding) error {
+
+	scanner := bufio.NewScanner(dec.r)
 	scanner.Split(bufio.ScanLines)
+	scanner.Buffer(nil, maxLineLength)
 
 	var section sectionEnum
-	var progrow int
+	var explicit bool
+	var progrow, linenum int
+	var errs ErrorList
+	var hdr Header
+	hdrLines := map[string]srcLine{}
+	tree := &syntaxTree{}
+
+	// fail records the error and reports whether decoding must stop
+	fail := func(err error) bool {
+		errs.Add(err)
+		return !dec.AllErrors
+	}
 
 	pal := NewPalette()
 	prog := Program{}
 
-	// read each line of the file
+	// read each line of the input
 	for scanner.Scan() {
-		line := scanner.Text()
-		// remove comments
-		if j := strings.Index(line, "//"); j >= 0 {
-			line = line[0:j]
+		linenum++
+		raw := scanner.Text()
+		line := newSrcLine(raw, linenum)
+		if len(line.text) == 0 {
+			tree.add(nodeBlank, section, raw, "")
+			continue
 		}
 
-		line = strings.TrimSpace(line)
-		if len(line) == 0 {
+		if m := reSectionMarker.FindStringSubmatch(line.text); m != nil {
+			sec, ok := sectionNames[strings.ToLower(m[1])]
+			if !ok {
+				if fail(line.error(InvalidSection, 0, m[1], nil)) {
+					break
+				}
+				continue
+			}
+			section = sec
+			explicit = true
+			tree.add(nodeMarker, section, raw, "["+m[1]+"]")
+			continue
+		}
+
+		if section == sectionHeader {
+			key, err := parseRowHeader(line, &hdr)
+			if err == nil {
+				hdrLines[key] = line
+				n := tree.add(nodeHeader, section, raw, hdr.row(key))
+				n.key = key
+			} else if fail(err) {
+				break
+			}
 			continue
 		}
 
 		if section == sectionLegend {
-			key, col, err := parseRowLegend(line)
+			lr, err := parseRowLegend(line)
 			if err == nil {
-				pal.Add(key, col)
-			} else if err == errInvalidLegendRow {
+				pal.Add(lr.key, lr.color)
+				pal.setNotation(lr.key, lr.notation)
+				if lr.ref != "" {
+					pal.setReference(lr.key, lr.ref)
+				}
+				n := tree.add(nodeLegend, section, raw, pal.row(lr.key))
+				n.key = lr.key
+			} else if err == errInvalidLegendRow && !explicit {
 				section = sectionProgram
 			} else {
-				return err
+				if err == errInvalidLegendRow {
+					err = line.error(InvalidLegend, 0, line.text, nil)
+				}
+				if fail(err) {
+					break
+				}
 			}
 		}
 
 		if section == sectionProgram {
-			instr, err := parseRowProgram(line, progrow)
+			stmt, err := parseRowProgram(line, progrow)
+			if stmt.last > 0 {
+				// keep counting the rows after a wrong row number,
+				// to avoid reporting the same error on every row
+				progrow = stmt.last
+			}
 			if err == nil {
-				progrow++
-				err = prog.Add(instr)
-				if err != nil {
-					return err
-				}
-
-			} else if err == errInvalidProgramRow {
-				section = sectionEnd
-			} else {
-				return err
+				err = prog.addStmt(stmt)
+			}
+			if err == nil {
+				n := tree.add(nodeProgram, section, raw, stmt.String())
+				n.row = stmt.first - 1
+				n.nrows = stmt.last - stmt.first + 1
+				n.rows = prog.text(n.row, n.nrows)
+			} else if fail(err) {
+				break
 			}
 		}
 	}
-	if e := prog.CheckColors(pal); e != nil {
-		return e
+	if err := scanner.Err(); err != nil {
+		// the rest of the input is lost: no more checks
+		l := srcLine{num: linenum + 1}
+		fail(l.error(InvalidLine, 0, "", err))
+	} else if len(errs) == 0 || dec.AllErrors {
+		if err := prog.CheckColors(pal); err != nil {
+			fail(err)
+		}
+		dx, dy := prog.Size()
+		if hdr.Width > 0 && dx > hdr.Width {
+			err := fmt.Errorf("the program is %d columns wide", dx)
+			fail(hdrLines["width"].error(SizeMismatch, 0, "width", err))
+		}
+		if hdr.Height > 0 && dy > hdr.Height {
+			err := fmt.Errorf("the program has %d rows", dy)
+			fail(hdrLines["height"].error(SizeMismatch, 0, "height", err))
+		}
+	}
+	if len(errs) > 0 {
+		for _, e := range errs {
+			e.File = dec.Filename
+		}
+		errs.Sort()
+		return errs.Err()
 	}
+	cod.hdr = hdr
 	cod.pal = pal
 	cod.prog = prog
+	cod.tree = tree
 
 	return nil
 
 }
 
-func parseRowLegend(s string) (string, color.Color, error) {
-	var (
-		err       error
-		colorName string
-		color     color.Color
-	)
+// legendRow is a row of the legend.
+type legendRow struct {
+	key      string
+	color    color.Color
+	notation string // the color as written in the row
+	ref      string // the reference to the catalog color, if any
+}
+
+// parseRowLegend parses the legend row in l, in the form
+// "key = color" or "key = color (catalog code)".
+func parseRowLegend(l srcLine) (legendRow, error) {
+	var lr legendRow
+	s := l.text
 
 	idx := strings.IndexRune(s, '=')
 	if idx < 0 {
-		err = errInvalidLegendRow
+		return lr, errInvalidLegendRow
 	}
 
-	if err == nil {
-		colorName = strings.TrimSpace(s[0:idx])
-		reColorName := regexp.MustCompile(`^[[:alpha:]]\w*$`)
-		if !reColorName.MatchString(colorName) {
-			err = errInvalidLegendRow
-		}
+	lr.key = strings.TrimSpace(s[0:idx])
+	if !reColorName.MatchString(lr.key) {
+		return lr, errInvalidLegendRow
 	}
 
-	if err == nil {
-		colorFormat := strings.TrimSpace(s[idx+1:])
-		//fmt.Printf("%s -> %v\n", name, color)
-		color, err = codimg.ParseColor(colorFormat)
-
+	value := l.slice(idx + 1)
+	lr.notation = value.text
+	if m := reCatalogRef.FindStringSubmatchIndex(value.text); m != nil {
+		lr.ref = strings.Join(strings.Fields(value.text[m[2]:m[3]]), " ")
+		value.text = strings.TrimSpace(value.text[:m[0]])
 	}
-
+	c, err := codimg.ParseColor(value.text)
 	if err != nil {
-		return "", nil, err
+		return lr, value.error(InvalidColor, 0, value.text, err)
 	}
+	lr.color = c
 
-	return colorName, color, nil
+	return lr, nil
 }
 
-func parseRowProgram(s string, prevRowNum int) (string, error) {
-	var (
-		err    error
-		rowNum int
-	)
-
-	idx := strings.IndexRune(s, '=')
-	if idx < 0 {
-		err = errInvalidProgramRow
-	}
+// Fprint writes the coding to w.
+// If the coding was read from a file, the layout of the file is kept:
+// comments, blank lines and unchanged rows are written as they were.
+// Otherwise, if the coding has a header, the sections are introduced
+// by the explicit markers.
+func (cod *Coding) Fprint(w io.Writer) {
+	cod.fprint(w, printOptions{})
+}
 
-	if err == nil {
-		rowNum, err = strconv.Atoi(strings.TrimSpace(s[0:idx]))
-		if err == nil {
-			if rowNum != prevRowNum+1 {
-				err = fmt.Errorf("Expecting row #%d of the program, found row #%d", prevRowNum+1, rowNum)
-			}
-		}
+// Format writes the coding to w in the canonical format.
+// Comments, blank lines and the notation of the colors
+// of the file the coding was read from are kept.
+func (cod *Coding) Format(w io.Writer) {
+	cod.fprint(w, printOptions{canonical: true})
+}
 
+func (cod *Coding) fprint(w io.Writer, opt printOptions) {
+	if cod.tree != nil {
+		cod.tree.fprint(w, cod, opt)
+		return
 	}
 
-	if err != nil {
-		return "", err
+	fprintProgram := cod.prog.Fprint
+	if opt.compact {
+		fprintProgram = cod.prog.FprintCompact
+	}
+	if cod.hdr.IsZero() {
+		fmt.Fprint(w, "// LEGENDA\n\n")
+		cod.pal.Fprint(w)
+		fmt.Fprint(w, "\n// PROGRAMMA\n\n")
+		fprintProgram(w)
+		return
 	}
+	fmt.Fprint(w, "[header]\n")
+	cod.hdr.Fprint(w)
+	fmt.Fprint(w, "\n[legend]\n")
+	cod.pal.Fprint(w)
+	fmt.Fprint(w, "\n[program]\n")
+	fprintProgram(w)
+}
 
-	return s[idx+1:], nil
+// An Encoder writes a coding to an output stream.
+type Encoder struct {
+	w io.Writer
+
+	// Canonical rewrites every row of the coding in the canonical format.
+	// By default, the unchanged rows of the file the coding
+	// was read from are written as they were.
+	Canonical bool
+	// Compact writes the new program rows in the compact syntax,
+	// with groups and copies of the repeated rows.
+	Compact bool
 }
 
-// Fprint writes the coding to w.
-func (cod *Coding) Fprint(w io.Writer) {
-	fmt.Fprint(w, "// LEGENDA\n\n")
-	cod.pal.Fprint(w)
-	fmt.Fprint(w, "\n// PROGRAMMA\n\n")
-	cod.prog.Fprint(w)
+// NewEncoder returns a new encoder that writes to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode writes the coding to the output stream.
+// It returns the first error encountered while writing.
+func (enc *Encoder) Encode(cod *Coding) error {
+	ew := &errWriter{w: enc.w}
+	cod.fprint(ew, printOptions{
+		canonical: enc.Canonical,
+		compact:   enc.Compact,
+	})
+	return ew.err
+}
+
+// errWriter is a writer that remembers the first error
+// and discards all the following writes.
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (ew *errWriter) Write(p []byte) (int, error) {
+	if ew.err != nil {
+		return 0, ew.err
+	}
+	var n int
+	n, ew.err = ew.w.Write(p)
+	return n, ew.err
 }
 
 // Print writes the coding to stdout
@@ -186,35 +419,90 @@ func (cod *Coding) Print() {
 	cod.Fprint(os.Stdout)
 }
 
-// Image return the paletted image genrated by the program and the palette of the coding.
-func (cod *Coding) Image() *image.Paletted {
+// Image returns the image generated by the program and the palette
+// of the coding. The image is a *image.Paletted, with the transparent
+// null color appended to the palette, if the palette has less than
+// 256 colors; otherwise it is a *image.NRGBA.
+func (cod *Coding) Image() image.Image {
+	if cod.pal.Len() < 256 {
+		return cod.paletted()
+	}
+	return cod.nrgba()
+}
+
+// Size returns the dimensions of the image of the coding.
+func (cod *Coding) Size() (int, int) {
 	dx, dy := cod.prog.Size()
-	bounds := image.Rect(0, 0, dx, dy)
-	pal := cod.pal.Palette()
-	// append the null color to the palette
-	nullIdx := uint8(len(pal))
-	pal = append(pal, color.Transparent)
+	if cod.hdr.Width > dx {
+		dx = cod.hdr.Width
+	}
+	if cod.hdr.Height > dy {
+		dy = cod.hdr.Height
+	}
+	return dx, dy
+}
 
-	img := image.NewPaletted(bounds, pal)
+// draw calls set for each pixel of the image of the coding, with the
+// index of the color in the palette, or -1 for the null color.
+func (cod *Coding) draw(set func(x, y, idx int)) {
+	dx, dy := cod.Size()
 
-	// set the pixels of the paletted image
 	var x int
-	var colorIdx uint8
 	for y, row := range cod.prog {
 		x = 0
-		//
 		for _, item := range row {
-			colorIdx = uint8(cod.pal.Key2Idx(item.k))
+			colorIdx := cod.pal.Key2Idx(item.k)
 			for j := 0; j < item.n; j++ {
-				img.SetColorIndex(x, y, colorIdx)
+				set(x, y, colorIdx)
 				x++
 			}
 		}
 		// complete the row, if needed
 		for ; x < dx; x++ {
-			img.SetColorIndex(x, y, nullIdx)
+			set(x, y, -1)
 		}
 	}
+	// complete the image, if needed
+	for y := len(cod.prog); y < dy; y++ {
+		for x = 0; x < dx; x++ {
+			set(x, y, -1)
+		}
+	}
+}
+
+// paletted returns the paletted image of the coding.
+// The palette must have less than 256 colors.
+func (cod *Coding) paletted() *image.Paletted {
+	dx, dy := cod.Size()
+	pal := cod.pal.Palette()
+	// append the null color to the palette
+	nullIdx := uint8(len(pal))
+	pal = append(pal, color.Transparent)
+
+	img := image.NewPaletted(image.Rect(0, 0, dx, dy), pal)
+	cod.draw(func(x, y, idx int) {
+		if idx < 0 {
+			img.SetColorIndex(x, y, nullIdx)
+		} else {
+			img.SetColorIndex(x, y, uint8(idx))
+		}
+	})
+	return img
+}
+
+// nrgba returns the full color image of the coding.
+func (cod *Coding) nrgba() *image.NRGBA {
+	dx, dy := cod.Size()
+	pal := cod.pal.Palette()
+
+	img := image.NewNRGBA(image.Rect(0, 0, dx, dy))
+	cod.draw(func(x, y, idx int) {
+		if idx < 0 {
+			img.Set(x, y, color.Transparent)
+		} else {
+			img.Set(x, y, pal[idx])
+		}
+	})
 	return img
 }
 
@@ -225,9 +513,10 @@ func (cod *Coding) SaveAs(path string) error {
 	if err != nil {
 		return err
 	}
-	defer w.Close()
 
-	cod.Fprint(w)
-
-	return nil
+	err = NewEncoder(w).Encode(cod)
+	if e := w.Close(); err == nil {
+		err = e
+	}
+	return err
 }
@@ -0,0 +1,693 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+	"testing"
+
+	codimg "github.com/mmbros/test/coding/image"
+)
+
+const testCoding = `// LEGENDA
+
+x = rosa
+b = marrone // brown
+
+// PROGRAMMA
+
+1 = 3x 1b 1x
+2 = 1x 3b 1x
+`
+
+func programString(p Program) string {
+	var buf bytes.Buffer
+	p.Fprint(&buf)
+	return buf.String()
+}
+
+func TestDecodeEncode(t *testing.T) {
+	cod := NewCoding()
+	if err := NewDecoder(strings.NewReader(testCoding)).Decode(cod); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if dx, dy := cod.prog.Size(); dx != 5 || dy != 2 {
+		t.Errorf("Expected size 5x2, found %dx%d", dx, dy)
+	}
+	if cod.pal.Len() != 2 {
+		t.Errorf("Expected 2 colors, found %d", cod.pal.Len())
+	}
+
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf).Encode(cod); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	cod2 := NewCoding()
+	if err := NewDecoder(&buf).Decode(cod2); err != nil {
+		t.Fatalf("Unexpected error decoding the encoded coding: %s", err)
+	}
+	if a, b := programString(cod.prog), programString(cod2.prog); a != b {
+		t.Errorf("Program changed after round trip:\n%s\n%s", a, b)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	var testCases = []string{
+		"x = rosa\n1 = 3y\n",
+		"x = rosa\n2 = 3x\n",
+		"x = rosa\n1 = 0x\n",
+		"x = #12g\n",
+		// counts and rows beyond the limits
+		"x = rosa\n1 = 99999999999999999999x\n",
+		"x = rosa\n1 = 10001x\n",
+		"x = rosa\n1 = 6000x 6000(1x)\n",
+		"x = rosa\n1 = 999999999(1x)\n",
+		"x = rosa\n1 = 100(100(2x))\n",
+		"x = rosa\n1-1000000000 = 1x\n",
+		"x = rosa\n1-99999999999999999999 = 1x\n",
+		"x = rosa\nb = blu\n1 = <5000x 1b>\n",
+		"x = rosa\nb = blu\n1 = " + strings.Repeat("<", 26) + "1x 1b" + strings.Repeat(">", 26) + "\n",
+	}
+	for _, tc := range testCases {
+		cod := NewCoding()
+		if err := NewDecoder(strings.NewReader(tc)).Decode(cod); err == nil {
+			t.Errorf("Expected error for input %q", tc)
+		}
+	}
+}
+
+func TestDecodeAllErrors(t *testing.T) {
+	const input = `x = rosa
+y = #12g // bad color
+
+1 = 3x 0x
+2 = 2x 1z
+4 = 1x
+5 = 1k
+6 = 2(1x 3(1b))
+7-9 = 1q
+`
+	var testCases = []struct {
+		line, col int
+		kind      ErrorKind
+	}{
+		{2, 5, InvalidColor},
+		{4, 8, InvalidItem},
+		{5, 8, UnknownColor},
+		{6, 1, InvalidRowNumber},
+		{7, 5, UnknownColor},
+		// once, though repeated
+		{8, 12, UnknownColor},
+		{9, 7, UnknownColor},
+	}
+
+	dec := NewDecoder(strings.NewReader(input))
+	dec.Filename = "test.txt"
+	dec.AllErrors = true
+	err := dec.Decode(NewCoding())
+	list, ok := err.(ErrorList)
+	if !ok {
+		t.Fatalf("Expected ErrorList, found %v", err)
+	}
+	if len(list) != len(testCases) {
+		t.Fatalf("Expected %d errors, found %d: %v", len(testCases), len(list), list)
+	}
+	for j, tc := range testCases {
+		e := list[j]
+		if e.File != "test.txt" || e.Line != tc.line || e.Column != tc.col || e.Kind != tc.kind {
+			t.Errorf("Error #%d: expected %d:%d %s, found %s", j, tc.line, tc.col, tc.kind, e)
+		}
+	}
+}
+
+func TestDecodeSections(t *testing.T) {
+	const input = `[header]
+title = Ciao
+width = 6
+source = http://example.com/ciao.png
+
+[legend]
+x = rosa
+1b = marrone
+
+[program]
+1 = 3x 1b
+`
+	const expected = `[header]
+title = Ciao
+width = 6
+source = http://example.com/ciao.png
+
+[legend]
+x = rosa
+b = marrone
+
+[program]
+1 = 3x 1b
+`
+	cod := NewCoding()
+	err := NewDecoder(strings.NewReader(input)).Decode(cod)
+	if e, ok := err.(*ParseError); !ok || e.Kind != InvalidLegend || e.Line != 8 {
+		t.Fatalf("Expected invalid legend row at line 8, found %v", err)
+	}
+
+	err = NewDecoder(strings.NewReader(expected)).Decode(cod)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if cod.hdr.Source != "http://example.com/ciao.png" {
+		t.Errorf("Unexpected source %q", cod.hdr.Source)
+	}
+	if dx := cod.Image().Bounds().Dx(); dx != 6 {
+		t.Errorf("Expected image width 6, found %d", dx)
+	}
+
+	var buf bytes.Buffer
+	cod.Fprint(&buf)
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\nfound:\n%s", expected, buf.String())
+	}
+}
+
+func TestDecodeTrailingRows(t *testing.T) {
+	const input = "x = rosa\n1 = 3x\nthe end\n"
+	err := NewDecoder(strings.NewReader(input)).Decode(NewCoding())
+	if e, ok := err.(*ParseError); !ok || e.Kind != InvalidProgramRow || e.Line != 3 {
+		t.Errorf("Expected invalid program row at line 3, found %v", err)
+	}
+}
+
+func TestLosslessRoundTrip(t *testing.T) {
+	const input = `// LEGENDA
+
+x   = #e58297  // rosa
+b = marrone
+
+// PROGRAMMA
+
+1 =  3x 1b   // first row
+2 = 1x 3b
+`
+	const expected = `// LEGENDA
+
+x   = #e58297  // rosa
+b = marrone
+n = nero
+
+// PROGRAMMA
+
+1 =  3x 1b   // first row
+2 = 4n
+`
+	cod := NewCoding()
+	if err := NewDecoder(strings.NewReader(input)).Decode(cod); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	var buf bytes.Buffer
+	cod.Fprint(&buf)
+	if buf.String() != input {
+		t.Errorf("Expected:\n%s\nfound:\n%s", input, buf.String())
+	}
+
+	cod.pal.Add("n", color.Black)
+	cod.prog[1] = ProgramRow{{n: 4, k: "n"}}
+
+	buf.Reset()
+	cod.Fprint(&buf)
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\nfound:\n%s", expected, buf.String())
+	}
+}
+
+func TestColorKeys(t *testing.T) {
+	pal := color.Palette{
+		color.NRGBA{255, 0, 0, 255},
+		color.NRGBA{250, 10, 10, 255},
+		color.NRGBA{0, 0, 0, 255},
+		color.NRGBA{0, 0, 0, 0},
+		color.NRGBA{173, 216, 230, 255},
+		color.NRGBA{254, 0, 0, 255},
+	}
+	expected := []string{"rosso", "rosso2", "nero", "trasparente", "blu_chiaro", "rosso3"}
+
+	keys := colorKeys(pal)
+	for j, k := range keys {
+		if k != expected[j] {
+			t.Errorf("Color %v: expected key %q, found %q", pal[j], expected[j], k)
+		}
+		if !reColorName.MatchString(k) {
+			t.Errorf("Invalid key %q", k)
+		}
+	}
+	if validKey("2 rossi") != "c2_rossi" {
+		t.Errorf("Unexpected key %q", validKey("2 rossi"))
+	}
+}
+
+func TestNewProgramItem(t *testing.T) {
+	var testCases = []struct {
+		input string
+		n     int
+		k     string
+	}{
+		{"3x", 3, "x"},
+		{"x", 1, "x"},
+		{"12rosso2", 12, "rosso2"},
+		{"rosso2", 1, "rosso2"},
+		{"7", 7, ""},
+		{"0x", 0, "x"},
+	}
+	for _, tc := range testCases {
+		pi := newProgramItem(tc.input)
+		if pi.n != tc.n || pi.k != tc.k {
+			t.Errorf("Input %q: expected (%d, %q), found (%d, %q)", tc.input, tc.n, tc.k, pi.n, pi.k)
+		}
+	}
+}
+
+func TestImageManyColors(t *testing.T) {
+	const width = 300
+
+	m := image.NewNRGBA(image.Rect(0, 0, width, 2))
+	for x := 0; x < width; x++ {
+		// the first row has a distinct color for each pixel,
+		// the second row is a single run of width pixels
+		m.Set(x, 0, color.NRGBA{uint8(x), uint8(x >> 8), 100, 255})
+		m.Set(x, 1, color.White)
+	}
+
+	cod, err := image2coding(m)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if cod.pal.Len() != width+1 {
+		t.Fatalf("Expected %d colors, found %d", width+1, cod.pal.Len())
+	}
+	if len(cod.prog[1]) != 1 || cod.prog[1][0].n != width {
+		t.Errorf("Expected a single run of %d pixels, found %s", width, cod.prog[1])
+	}
+
+	img, ok := cod.Image().(*image.NRGBA)
+	if !ok {
+		t.Fatalf("Expected *image.NRGBA, found %T", cod.Image())
+	}
+	for x := 0; x < width; x++ {
+		if !colorsEq(img.At(x, 0), m.At(x, 0)) || !colorsEq(img.At(x, 1), m.At(x, 1)) {
+			t.Fatalf("Unexpected color at column %d", x)
+		}
+	}
+}
+
+func colorsEq(c1, c2 color.Color) bool {
+	r1, g1, b1, a1 := c1.RGBA()
+	r2, g2, b2, a2 := c2.RGBA()
+	return r1 == r2 && g1 == g2 && b1 == b2 && a1 == a2
+}
+
+func TestCompactSyntax(t *testing.T) {
+	const input = `x = rosa
+b = marrone
+
+1 = 2x 3(1b 1x)  // groups
+2-3 = <1x 2b 3x>
+4 = same as 1
+5 = 1b 2((1x 1b) 1x)
+`
+	var expected = []string{
+		"2x 1b 1x 1b 1x 1b 1x",
+		"1x 2b 3x 2b 1x",
+		"1x 2b 3x 2b 1x",
+		"2x 1b 1x 1b 1x 1b 1x",
+		"1b 1x 1b 2x 1b 1x",
+	}
+
+	cod := NewCoding()
+	if err := NewDecoder(strings.NewReader(input)).Decode(cod); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(cod.prog) != len(expected) {
+		t.Fatalf("Expected %d rows, found %d", len(expected), len(cod.prog))
+	}
+	for j, r := range cod.prog {
+		if r.String() != expected[j] {
+			t.Errorf("Row %d: expected %q, found %q", j+1, expected[j], r.String())
+		}
+	}
+
+	// unchanged statements are kept
+	var buf bytes.Buffer
+	cod.Fprint(&buf)
+	if buf.String() != input {
+		t.Errorf("Expected:\n%s\nfound:\n%s", input, buf.String())
+	}
+
+	// the compact form expands to the same program
+	buf.Reset()
+	cod.prog.FprintCompact(&buf)
+	prog := Program{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		stmt, err := parseRowProgram(srcLine{text: line}, len(prog))
+		if err == nil {
+			err = prog.addStmt(stmt)
+		}
+		if err != nil {
+			t.Fatalf("Unexpected error for row %q: %s", line, err)
+		}
+	}
+	if a, b := programString(cod.prog), programString(prog); a != b {
+		t.Errorf("Program changed after compaction:\n%s\n%s", a, b)
+	}
+}
+
+func TestCompactSyntaxErrors(t *testing.T) {
+	var testCases = []struct {
+		input string
+		kind  ErrorKind
+	}{
+		{"1 = 2(1x", InvalidGroup},
+		{"1 = 1x)", InvalidGroup},
+		{"1 = <>", InvalidGroup},
+		{"1 = 0(1x)", InvalidItem},
+		{"1 = same as 1", InvalidReference},
+		{"1 = 1x\n2 = same as 3", InvalidReference},
+		{"2-1 = 1x", InvalidRowNumber},
+		{"1 = 1x\n2 = 1x\n3-5 = mirror of 1-2", InvalidReference},
+		{"1 = 1x\n2-3 = mirror of 1-2", InvalidReference},
+	}
+	for _, tc := range testCases {
+		err := NewDecoder(strings.NewReader("x = rosa\n" + tc.input)).Decode(NewCoding())
+		if e, ok := err.(*ParseError); !ok || e.Kind != tc.kind {
+			t.Errorf("Input %q: expected %s, found %v", tc.input, tc.kind, err)
+		}
+	}
+}
+
+func TestSymmetry(t *testing.T) {
+	var testCases = []struct {
+		input    string
+		symmetry string
+		compact  string
+	}{
+		{"1 = 1x 2b 1x\n2 = 2b 1x 1b\n", "", "1 = <1x 2b>\n2 = 2b 1x 1b\n"},
+		{"1 = 1x 2b 1x\n2 = 1b 2x 1b\n", SymmetryLeftRight, "1 = <1x 2b>\n2 = <1b 2x>\n"},
+		{"1 = 1x 1b\n2 = 2b\n3 = 1x 1b\n", SymmetryTopBottom, "1 = 1x 1b\n2 = 2b\n3 = mirror of 1\n"},
+		{"1 = 1x 1b 1x\n2 = 1b 1x 1b\n3 = 3b\n4 = 1b 1x 1b\n5 = 1x 1b 1x\n", SymmetryBoth,
+			"1 = <1x 1b>\n2 = <1b 1x>\n3 = 3b\n4-5 = mirror of 1-2\n"},
+	}
+	for _, tc := range testCases {
+		cod := NewCoding()
+		if err := NewDecoder(strings.NewReader("x = rosa\nb = blu\n" + tc.input)).Decode(cod); err != nil {
+			t.Fatalf("Input %q: unexpected error: %s", tc.input, err)
+		}
+		if s := cod.prog.Symmetry(); s != tc.symmetry {
+			t.Errorf("Input %q: expected symmetry %q, found %q", tc.input, tc.symmetry, s)
+		}
+		var buf bytes.Buffer
+		cod.prog.FprintCompact(&buf)
+		if buf.String() != tc.compact {
+			t.Errorf("Input %q: expected compact\n%s\nfound\n%s", tc.input, tc.compact, buf.String())
+		}
+
+		// the mirror shorthand expands to the same program
+		cod2 := NewCoding()
+		if err := NewDecoder(strings.NewReader("x = rosa\nb = blu\n" + buf.String())).Decode(cod2); err != nil {
+			t.Fatalf("Input %q: unexpected error: %s", buf.String(), err)
+		}
+		if a, b := programString(cod.prog), programString(cod2.prog); a != b {
+			t.Errorf("Input %q: program changed after compaction:\n%s\n%s", tc.input, a, b)
+		}
+	}
+}
+
+func TestChartPages(t *testing.T) {
+	const input = "x = rosa\nb = blu\n1-25 = 15x 10b\n"
+	cod := NewCoding()
+	if err := NewDecoder(strings.NewReader(input)).Decode(cod); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	opt := ChartOptions{CellSize: 10, Color: true, Symbols: true, PageCols: 10, PageRows: 20}
+	pages := cod.ChartPages(&opt)
+	if len(pages) != 6 {
+		t.Fatalf("Expected 6 pages, found %d", len(pages))
+	}
+	// the grid of the last page has 5 columns and 5 rows
+	if a, b := pages[0].Bounds(), pages[5].Bounds(); b.Dx() >= a.Dx() || b.Dy() >= a.Dy() {
+		t.Errorf("Expected last page smaller than the first, found %v and %v", b, a)
+	}
+	var buf bytes.Buffer
+	if err := codimg.EncodePdf(&buf, pages, 0); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if n := strings.Count(buf.String(), "/Type /Page "); n != len(pages) {
+		t.Errorf("Expected %d pdf pages, found %d", len(pages), n)
+	}
+}
+
+func TestChartSymbols(t *testing.T) {
+	n := len(chartSymbols)
+	seen := map[string]bool{}
+	for idx := 0; idx < n+n*n+10; idx++ {
+		s := chartSymbol(idx)
+		if seen[s] {
+			t.Fatalf("Symbol %q of color %d already used", s, idx)
+		}
+		seen[s] = true
+	}
+
+	// a palette of 100 colors
+	cod := NewCoding()
+	for j := 0; j < 100; j++ {
+		cod.pal.Add(fmt.Sprintf("c%d", j), color.NRGBA{uint8(j), uint8(2 * j), 0, 255})
+	}
+	for _, size := range []int{20, 10} {
+		c := newChart(cod, &ChartOptions{CellSize: size, Symbols: true})
+		for _, idx := range []int{0, 40, 99, 6000} {
+			m := image.NewNRGBA(image.Rect(0, 0, 3*size, 3*size))
+			fillRect(m, m.Rect, color.White)
+			cell := image.Rect(size, size, 2*size, 2*size)
+			c.drawCell(m, size, size, idx, color.White)
+			var inside int
+			for y := 0; y < m.Rect.Dy(); y++ {
+				for x := 0; x < m.Rect.Dx(); x++ {
+					if m.NRGBAAt(x, y) == (color.NRGBA{255, 255, 255, 255}) {
+						continue
+					}
+					if !image.Pt(x, y).In(cell) {
+						t.Fatalf("Cell size %d, symbol %q: pixel (%d,%d) outside of the cell %v", size, chartSymbol(idx), x, y, cell)
+					}
+					inside++
+				}
+			}
+			if inside == 0 {
+				t.Errorf("Cell size %d, symbol %q: not drawn", size, chartSymbol(idx))
+			}
+		}
+	}
+}
+
+func TestEncodeSvg(t *testing.T) {
+	const input = "x = rosa\nb = #00f8\n1 = 2x 3b\n2 = 1b\n"
+	const expected = `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 5 2" width="5" height="2" shape-rendering="crispEdges">
+<style>
+.x { fill: #ffc0cb; }
+.b { fill: #0000ff; fill-opacity: 0.533; }
+</style>
+<rect class="x" x="0" y="0" width="2" height="1"/>
+<rect class="b" x="2" y="0" width="3" height="1"/>
+<rect class="b" x="0" y="1" width="1" height="1"/>
+</svg>
+`
+	cod := NewCoding()
+	if err := NewDecoder(strings.NewReader(input)).Decode(cod); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	var buf bytes.Buffer
+	if err := cod.EncodeSvg(&buf, 1, codimg.Aspect{}); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\nfound:\n%s", expected, buf.String())
+	}
+
+	// cells of 6 pixels, with the aspect 5:4
+	buf.Reset()
+	if err := cod.EncodeSvg(&buf, 6, codimg.Aspect{W: 5, H: 4}); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	const header = `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 5 2" width="30" height="9.6" preserveAspectRatio="none" shape-rendering="crispEdges">`
+	if s := buf.String(); !strings.HasPrefix(s, header) {
+		t.Errorf("Expected header:\n%s\nfound:\n%s", header, s[:strings.IndexByte(s, '\n')])
+	}
+
+	// the paletted image has the same runs
+	runs := codimg.PalettedRuns(cod.Image().(*image.Paletted))
+	if len(runs) != 3 || runs[1] != (codimg.SvgRun{X: 2, Y: 0, N: 3, Class: 1}) {
+		t.Errorf("Unexpected runs of the paletted image: %v", runs)
+	}
+}
+
+func TestProgress(t *testing.T) {
+	const input = "x = rosa\nb = blu\n1 = 2x 3b\n2 = 1b 4x\n"
+	cod := NewCoding()
+	if err := NewDecoder(strings.NewReader(input)).Decode(cod); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	p := NewProgress(cod)
+	p.Next(3)
+	if p.Row != 1 || p.Item != 1 {
+		t.Errorf("Expected row 1 item 1, found row %d item %d", p.Row, p.Item)
+	}
+	if done, todo := p.Completed(), p.Remaining(); done["x"] != 2 || done["b"] != 4 || todo["x"] != 4 || todo["b"] != 0 {
+		t.Errorf("Unexpected completed %v and remaining %v", done, todo)
+	}
+
+	// save and read back
+	var buf bytes.Buffer
+	p.Fprint(&buf)
+	p2, err := ReadProgress(&buf, cod)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if p2.Row != p.Row || p2.Item != p.Item {
+		t.Errorf("Expected row %d item %d, found row %d item %d", p.Row, p.Item, p2.Row, p2.Item)
+	}
+
+	p.Next(10)
+	if !p.Done() || p.Current() != nil {
+		t.Errorf("Expected the work done, found row %d item %d", p.Row, p.Item)
+	}
+	p.Prev(1)
+	if p.Row != 1 || p.Item != 1 {
+		t.Errorf("Expected row 1 item 1, found row %d item %d", p.Row, p.Item)
+	}
+
+	for _, s := range []string{"row = 4\n", "row = 1\nitem = 3\n", "rows = 1\n"} {
+		if _, err := ReadProgress(strings.NewReader(s), cod); err == nil {
+			t.Errorf("Input %q: expected error, found nil", s)
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	const input = "x = rosa\nb = blu\nn = nero\n1 = 2x 3b\n2 = 1b 2x 1b\n"
+	cod := NewCoding()
+	if err := NewDecoder(strings.NewReader(input)).Decode(cod); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	st := cod.Stats(&YarnOptions{StitchSize: 1, YarnFactor: 5, SkeinLength: 0.1})
+	expected := []ColorStats{
+		{"x", 4, 40, 0.2, 2},
+		{"b", 5, 50, 0.25, 2.5},
+		{"n", 0, 0, 0, 0},
+	}
+	if len(st.Colors) != len(expected) {
+		t.Fatalf("Expected %d colors, found %d", len(expected), len(st.Colors))
+	}
+	for j, cs := range st.Colors {
+		e := expected[j]
+		if cs.Key != e.Key || cs.Cells != e.Cells || math.Abs(cs.Percent-e.Percent) > 1e-9 ||
+			math.Abs(cs.Meters-e.Meters) > 1e-9 || math.Abs(cs.Skeins-e.Skeins) > 1e-9 {
+			t.Errorf("Color %d: expected %v, found %v", j, e, cs)
+		}
+	}
+	if max, row := st.MaxChanges(); st.TotalChanges() != 3 || max != 2 || row != 1 {
+		t.Errorf("Expected 3 changes, at most 2 in row 1, found %d, %d in row %d", st.TotalChanges(), max, row)
+	}
+}
+
+func TestCatalogReference(t *testing.T) {
+	const input = "n = #000 (dmc 310)\nr = rgb(199, 43, 59) ( dmc   321 )\nb = blu\n1 = 1n 1r 1b\n"
+	cod := NewCoding()
+	if err := NewDecoder(strings.NewReader(input)).Decode(cod); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	for k, ref := range map[string]string{"n": "dmc 310", "r": "dmc 321", "b": ""} {
+		if s := cod.pal.Reference(k); s != ref {
+			t.Errorf("Key %q: expected reference %q, found %q", k, ref, s)
+		}
+	}
+
+	cat := codimg.NewCatalog("hama", []codimg.CatalogColor{{Code: "18", Name: "Black", Color: color.Black}})
+	cod = NewCoding()
+	cod.pal.Add("n", color.NRGBA{0, 0, 0, 255})
+	setCatalogReferences(cod, cat)
+	if s := cod.pal.row("n"); s != "n = nero (hama 18)" {
+		t.Errorf("Expected %q, found %q", "n = nero (hama 18)", s)
+	}
+}
+
+func TestAnimation(t *testing.T) {
+	const input = "x = rosa\nb = blu\n1 = 2x 3b\n2 = 1b 4x\n"
+	cod := NewCoding()
+	if err := NewDecoder(strings.NewReader(input)).Decode(cod); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	testCases := []struct {
+		byItem bool
+		step   int
+		frames int
+	}{
+		{false, 1, 3},
+		{false, 5, 2},
+		{true, 1, 5},
+		{true, 3, 3},
+	}
+	pal := cod.paletted().Palette
+	for _, tc := range testCases {
+		opt := DefaultAnimationOptions
+		opt.ByItem, opt.Step = tc.byItem, tc.step
+		anim, err := cod.Animation(&opt)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		if len(anim.Image) != tc.frames {
+			t.Errorf("Item %v, step %d: expected %d frames, found %d", tc.byItem, tc.step, tc.frames, len(anim.Image))
+			continue
+		}
+		first := anim.Image[0]
+		for j, c := range pal {
+			if first.Palette[j] != color.NRGBAModel.Convert(c) {
+				t.Errorf("Item %v, step %d: expected color %d %v, found %v", tc.byItem, tc.step, j, c, first.Palette[j])
+			}
+		}
+		for j, m := range anim.Image[1:] {
+			if !m.Rect.In(first.Rect) {
+				t.Errorf("Item %v, step %d: frame %d %v outside of %v", tc.byItem, tc.step, j+1, m.Rect, first.Rect)
+			}
+		}
+		if d := anim.Delay[len(anim.Delay)-1]; d != opt.FinalDelay {
+			t.Errorf("Item %v, step %d: expected final delay %d, found %d", tc.byItem, tc.step, opt.FinalDelay, d)
+		}
+	}
+}
+
+func TestLongLines(t *testing.T) {
+	// a row of 10000 cells, longer than the default buffer of a scanner
+	var b strings.Builder
+	b.WriteString("aaaaaaaa = rosa\nbbbbbbbb = blu\n1 =")
+	for j := 0; j < 5000; j++ {
+		b.WriteString(" 1aaaaaaaa 1bbbbbbbb")
+	}
+	b.WriteString("\n")
+	cod := NewCoding()
+	if err := NewDecoder(strings.NewReader(b.String())).Decode(cod); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if dx, dy := cod.Size(); dx != 10000 || dy != 1 {
+		t.Errorf("Expected size 10000x1, found %dx%d", dx, dy)
+	}
+
+	// a line too long
+	input := "x = rosa\n1 = 1x\n2 = " + strings.Repeat("1x ", maxLineLength/3+1) + "\n"
+	err := NewDecoder(strings.NewReader(input)).Decode(NewCoding())
+	if e, ok := err.(*ParseError); !ok || e.Kind != InvalidLine || e.Line != 3 {
+		t.Errorf("Expected unreadable line 3, found %v", err)
+	}
+}
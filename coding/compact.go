@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// equalItems reports whether the two lists of items are equal.
+func equalItems(a, b []*ProgramItem) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for j := range a {
+		if a[j].n != b[j].n || a[j].k != b[j].k {
+			return false
+		}
+	}
+	return true
+}
+
+// compactItems returns the items of the row in the compact syntax,
+// grouping the repeated sequences of items.
+// A symmetric row is written as a mirrored group.
+func compactItems(r ProgramRow) string {
+	if len(r) >= 3 && r.isMirror() {
+		s := "<" + compactItems(r[:len(r)/2+1]) + ">"
+		if r2, err := parseItems(srcLine{text: s}); err == nil && r2.String() == r.String() {
+			return s
+		}
+	}
+
+	var a []string
+	for i := 0; i < len(r); {
+		// find the group saving the most items
+		bestLen, bestReps := 0, 1
+		for L := 2; i+2*L <= len(r); L++ {
+			reps := 1
+			for i+(reps+1)*L <= len(r) && equalItems(r[i:i+L], r[i+reps*L:i+(reps+1)*L]) {
+				reps++
+			}
+			if reps > 1 && L*(reps-1) > bestLen*(bestReps-1) {
+				bestLen, bestReps = L, reps
+			}
+		}
+		if bestLen > 0 {
+			a = append(a, fmt.Sprintf("%d(%s)", bestReps, r[i:i+bestLen]))
+			i += bestLen * bestReps
+		} else {
+			a = append(a, r[i].String())
+			i++
+		}
+	}
+	s := strings.Join(a, " ")
+
+	// check the compact form expands to the row
+	if r2, err := parseItems(srcLine{text: s}); err != nil || r2.String() != r.String() {
+		return r.String()
+	}
+	return s
+}
+
+// compact returns the statements, in the compact syntax,
+// of the rows of the program starting from row from (0-based).
+// A row equal to a previous one is written as a copy of it;
+// consecutive equal rows are written as a range.
+// If the program is symmetric top to bottom, the second half
+// is written as the mirror of the first one.
+func (p Program) compact(from int) []string {
+	if n, h := len(p), (len(p)+1)/2; n > 1 && from <= h && p.isMirror() {
+		lines := p[:h].compact(from)
+		return append(lines, fmt.Sprintf("%s = mirror of %s", rowRange(h, n-1), rowRange(0, n-h-1)))
+	}
+
+	var lines []string
+	first := map[string]int{}
+
+	for i := 0; i < len(p); {
+		s := p[i].String()
+		j := i + 1
+		for j < len(p) && p[j].String() == s {
+			j++
+		}
+		// rows from i to j-1 are equal
+		src, ok := first[s]
+		if !ok {
+			src = i
+			first[s] = i
+		}
+		start := i
+		if start < from {
+			start = from
+		}
+		switch {
+		case j <= from:
+			// already written, but can be referenced
+		case src < start:
+			lines = append(lines, fmt.Sprintf("%s = same as %d", rowRange(start, j-1), src+1))
+		default:
+			lines = append(lines, fmt.Sprintf("%s = %s", rowRange(i, j-1), compactItems(p[i])))
+		}
+		i = j
+	}
+	return lines
+}
+
+// FprintCompact writes to w a representation of the Program
+// in the compact syntax.
+func (p Program) FprintCompact(w io.Writer) {
+	for _, s := range p.compact(0) {
+		fmt.Fprintln(w, s)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+
+	codimg "github.com/mmbros/test/coding/image"
+)
+
+// comparePalettes returns an image with the paletted images side by side,
+// zoomed by zoom, each one with its name above and the swatches
+// of its palette below.
+func comparePalettes(names []string, images []*image.Paletted, zoom int) image.Image {
+	const swatch = 16
+	lineH := chartFace.Height
+
+	// size of a column
+	var colW, imgH, maxColors int
+	for j, m := range images {
+		b := m.Bounds()
+		w := b.Dx() * zoom
+		if tw := textWidth(names[j]); tw > w {
+			w = tw
+		}
+		if w > colW {
+			colW = w
+		}
+		if h := b.Dy() * zoom; h > imgH {
+			imgH = h
+		}
+		if n := len(m.Palette); n > maxColors {
+			maxColors = n
+		}
+	}
+	perRow := colW / swatch
+	if perRow < 1 {
+		perRow = 1
+	}
+	swatchRows := (maxColors + perRow - 1) / perRow
+
+	colH := lineH + chartPad + imgH + chartPad + swatchRows*swatch
+	out := image.NewRGBA(image.Rect(0, 0, len(images)*(colW+2*chartPad)+chartPad, colH+2*chartPad))
+	fillRect(out, out.Bounds(), color.White)
+
+	for j, m := range images {
+		x := chartPad + j*(colW+2*chartPad)
+		y := chartPad
+		drawText(out, x, y, names[j], chartText)
+		y += lineH + chartPad
+
+		zoomed, _ := codimg.Zoom(m, zoom, zoom)
+		draw.Draw(out, zoomed.Bounds().Add(image.Pt(x, y)), zoomed, image.Point{}, draw.Over)
+		y += imgH + chartPad
+
+		for k, c := range m.Palette {
+			sx := x + (k%perRow)*swatch
+			sy := y + (k/perRow)*swatch
+			fillRect(out, image.Rect(sx, sy, sx+swatch, sy+swatch), chartThinLine)
+			fillRect(out, image.Rect(sx+1, sy+1, sx+swatch-1, sy+swatch-1), c)
+		}
+	}
+	return out
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// ErrorKind identifies the kind of a ParseError.
+type ErrorKind int
+
+// The kinds of errors found parsing a coding file.
+const (
+	InvalidLegend ErrorKind = iota + 1
+	InvalidColor
+	InvalidRowNumber
+	InvalidItem
+	MissingColor
+	EmptyRow
+	UnknownColor
+	InvalidSection
+	InvalidHeader
+	InvalidProgramRow
+	SizeMismatch
+	InvalidGroup
+	InvalidReference
+	InvalidLine
+)
+
+var errorKindNames = map[ErrorKind]string{
+	InvalidLegend:     "invalid legend row",
+	InvalidColor:      "invalid color",
+	InvalidRowNumber:  "invalid row number",
+	InvalidItem:       "invalid program item",
+	MissingColor:      "missing color in program item",
+	EmptyRow:          "empty program row",
+	UnknownColor:      "unknown color",
+	InvalidSection:    "unknown section",
+	InvalidHeader:     "invalid header row",
+	InvalidProgramRow: "invalid program row",
+	SizeMismatch:      "size mismatch",
+	InvalidGroup:      "invalid group",
+	InvalidReference:  "invalid row reference",
+	InvalidLine:       "unreadable line",
+}
+
+func (k ErrorKind) String() string {
+	if s, ok := errorKindNames[k]; ok {
+		return s
+	}
+	return fmt.Sprintf("ErrorKind(%d)", int(k))
+}
+
+// ParseError represents an error found parsing a coding.
+// Line and Column are the 1-based position of the offending token
+// in the input. They are zero if the position is unknown,
+// as for programs built without a coding file.
+type ParseError struct {
+	File   string    // name of the coding file, if any
+	Line   int       // line number in the file
+	Column int       // column number (in bytes) in the line
+	Row    int       // program row number, if any
+	Token  string    // the offending token
+	Kind   ErrorKind // the kind of the error
+	Err    error     // the underlying error, if any
+}
+
+func (e *ParseError) Error() string {
+	var pos string
+	if e.File != "" {
+		pos = e.File + ":"
+	}
+	if e.Line > 0 {
+		pos += fmt.Sprintf("%d:%d:", e.Line, e.Column)
+	} else if e.Row > 0 {
+		pos += fmt.Sprintf("row #%d:", e.Row)
+	}
+	if pos != "" {
+		pos += " "
+	}
+
+	msg := e.Kind.String()
+	if e.Token != "" {
+		msg += fmt.Sprintf(" %q", e.Token)
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return pos + msg
+}
+
+// Unwrap returns the underlying error.
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorList is a list of *ParseError.
+type ErrorList []*ParseError
+
+// Add appends the errors contained in err to the list.
+// err must be a *ParseError or an ErrorList.
+func (p *ErrorList) Add(err error) {
+	switch e := err.(type) {
+	case *ParseError:
+		*p = append(*p, e)
+	case ErrorList:
+		*p = append(*p, e...)
+	default:
+		*p = append(*p, &ParseError{Err: err})
+	}
+}
+
+// Sort sorts the list by line and column.
+func (p ErrorList) Sort() {
+	sort.SliceStable(p, func(i, j int) bool {
+		if p[i].Line != p[j].Line {
+			return p[i].Line < p[j].Line
+		}
+		return p[i].Column < p[j].Column
+	})
+}
+
+func (p ErrorList) Error() string {
+	switch len(p) {
+	case 0:
+		return "no errors"
+	case 1:
+		return p[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", p[0], len(p)-1)
+}
+
+// Err returns an error equivalent to the list.
+// If the list is empty, Err returns nil.
+func (p ErrorList) Err() error {
+	switch len(p) {
+	case 0:
+		return nil
+	case 1:
+		return p[0]
+	}
+	return p
+}
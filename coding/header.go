@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	codimg "github.com/mmbros/test/coding/image"
+)
+
+// Header contains the metadata of a coding.
+// All the fields are optional.
+type Header struct {
+	Title  string
+	Author string
+	// Width and Height are the dimensions of the image.
+	// If greater than the dimensions of the program,
+	// the image is completed with the null color.
+	Width  int
+	Height int
+	// Source is the image the coding was generated from.
+	Source string
+	// Stitch is the kind of stitch or bead of the work.
+	Stitch string
+	// Aspect is the ratio between the width and the height of a cell,
+	// used to render the coding. The zero value means square cells.
+	Aspect codimg.Aspect
+	// Symmetry is the symmetry of the image, if any:
+	// the crafter can work the halves the same way.
+	Symmetry string
+}
+
+// The values of the symmetry header field.
+const (
+	SymmetryLeftRight = "left-right"
+	SymmetryTopBottom = "top-bottom"
+	SymmetryBoth      = "both"
+)
+
+// IsZero reports whether the header has no metadata.
+func (h *Header) IsZero() bool {
+	return *h == Header{}
+}
+
+// set sets the header field identified by key.
+func (h *Header) set(key, value string) error {
+	var err error
+
+	switch key {
+	case "title":
+		h.Title = value
+	case "author":
+		h.Author = value
+	case "width":
+		h.Width, err = parseDimension(value)
+	case "height":
+		h.Height, err = parseDimension(value)
+	case "source":
+		h.Source = value
+	case "stitch":
+		h.Stitch = value
+	case "aspect":
+		h.Aspect, err = codimg.ParseAspect(value)
+	case "symmetry":
+		switch v := strings.ToLower(value); v {
+		case "", SymmetryLeftRight, SymmetryTopBottom, SymmetryBoth:
+			h.Symmetry = v
+		default:
+			err = fmt.Errorf("invalid symmetry %q", value)
+		}
+	default:
+		err = fmt.Errorf("unknown header field %q", key)
+	}
+	return err
+}
+
+func parseDimension(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid dimension %q", s)
+	}
+	return n, nil
+}
+
+// parseRowHeader parses a header row in the form "key = value"
+// and sets the corresponding field of h. It returns the key.
+func parseRowHeader(l srcLine, h *Header) (string, error) {
+	s := l.text
+
+	idx := strings.IndexRune(s, '=')
+	if idx < 0 {
+		return "", l.error(InvalidHeader, 0, s, nil)
+	}
+	key := strings.ToLower(strings.TrimSpace(s[0:idx]))
+	value := l.slice(idx + 1)
+
+	if err := h.set(key, value.text); err != nil {
+		return "", l.error(InvalidHeader, 0, key, err)
+	}
+	return key, nil
+}
+
+// headerKeys are the keys of the header fields, in the output order.
+var headerKeys = []string{"title", "author", "width", "height", "source", "stitch", "aspect", "symmetry"}
+
+// row returns the header row of the field identified by key,
+// or an empty string if the field is not set.
+func (h *Header) row(key string) string {
+	var value string
+	switch key {
+	case "title":
+		value = h.Title
+	case "author":
+		value = h.Author
+	case "width":
+		if h.Width != 0 {
+			value = strconv.Itoa(h.Width)
+		}
+	case "height":
+		if h.Height != 0 {
+			value = strconv.Itoa(h.Height)
+		}
+	case "source":
+		value = h.Source
+	case "stitch":
+		value = h.Stitch
+	case "aspect":
+		if h.Aspect != (codimg.Aspect{}) {
+			value = h.Aspect.String()
+		}
+	case "symmetry":
+		value = h.Symmetry
+	}
+	if value == "" {
+		return ""
+	}
+	return key + " = " + value
+}
+
+// Fprint writes to w a representation of the header.
+// The output format can be readed back in the coding file.
+func (h *Header) Fprint(w io.Writer) {
+	for _, k := range headerKeys {
+		if s := h.row(k); s != "" {
+			fmt.Fprintln(w, s)
+		}
+	}
+}
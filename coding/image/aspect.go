@@ -0,0 +1,77 @@
+package image
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Aspect is the ratio W:H between the width and the height
+// of a cell of the grid: knit stitches, for example, are wider
+// than tall (about 5:4). The zero value means square cells.
+type Aspect struct {
+	W, H int
+}
+
+// ParseAspect parses an aspect ratio in the form "W:H", like "5:4".
+// A single number N means N:1.
+func ParseAspect(s string) (Aspect, error) {
+	w, h := strings.TrimSpace(s), "1"
+	if j := strings.IndexRune(s, ':'); j >= 0 {
+		w, h = strings.TrimSpace(s[:j]), strings.TrimSpace(s[j+1:])
+	}
+	a := Aspect{}
+	var err1, err2 error
+	a.W, err1 = strconv.Atoi(w)
+	a.H, err2 = strconv.Atoi(h)
+	if err1 != nil || err2 != nil || a.W <= 0 || a.H <= 0 {
+		return Aspect{}, fmt.Errorf("invalid aspect ratio %q", s)
+	}
+	return a, nil
+}
+
+// IsSquare reports whether the cells are square.
+func (a Aspect) IsSquare() bool {
+	return a.W == a.H
+}
+
+// Ratio returns the ratio between the width and the height of a cell.
+func (a Aspect) Ratio() float64 {
+	if a.W <= 0 || a.H <= 0 {
+		return 1
+	}
+	return float64(a.W) / float64(a.H)
+}
+
+func (a Aspect) String() string {
+	if a.W <= 0 || a.H <= 0 {
+		return "1:1"
+	}
+	return fmt.Sprintf("%d:%d", a.W, a.H)
+}
+
+// CellSize returns the size in pixels of a cell w pixels wide.
+// The height is at least 1 pixel.
+func (a Aspect) CellSize(w int) (int, int) {
+	h := int(math.Round(float64(w) / a.Ratio()))
+	if h < 1 {
+		h = 1
+	}
+	return w, h
+}
+
+// GridSize returns the number of rows of a grid of cols columns
+// covering the rectangle r, with cells of the given aspect.
+func GridSize(r image.Rectangle, cols int, a Aspect) (int, int) {
+	if cols <= 0 || r.Dx() == 0 {
+		return cols, 0
+	}
+	// the height of a cell is the width divided by the ratio
+	rows := int(math.Round(float64(r.Dy()) * float64(cols) * a.Ratio() / float64(r.Dx())))
+	if rows < 1 {
+		rows = 1
+	}
+	return cols, rows
+}
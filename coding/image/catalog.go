@@ -0,0 +1,195 @@
+package image
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/color"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// CatalogColor is a color of a catalog of real materials:
+// a thread, a bead, a brick.
+type CatalogColor struct {
+	Code  string // code of the color in the catalog
+	Name  string // name of the color in the catalog
+	Color color.Color
+}
+
+// Catalog is a catalog of the colors of real materials
+// (DMC floss, Hama or Perler beads, LEGO bricks, ...).
+type Catalog struct {
+	Name   string
+	Colors []CatalogColor
+	labs   []Lab
+}
+
+// NewCatalog returns a catalog with the given colors.
+func NewCatalog(name string, colors []CatalogColor) *Catalog {
+	cat := &Catalog{Name: name, Colors: colors}
+	cat.labs = make([]Lab, len(colors))
+	for j, c := range colors {
+		cat.labs[j] = ToLab(c.Color)
+	}
+	return cat
+}
+
+// Nearest returns the color of the catalog nearest (by CIEDE2000
+// distance) to c. The catalog must not be empty.
+func (cat *Catalog) Nearest(c color.Color) CatalogColor {
+	lab := ToLab(c)
+	best, bestDist := 0, -1.0
+	for j, l := range cat.labs {
+		if d := DeltaE2000(lab, l); bestDist < 0 || d < bestDist {
+			best, bestDist = j, d
+		}
+	}
+	return cat.Colors[best]
+}
+
+// Find returns the color of the catalog equal to c, if any.
+func (cat *Catalog) Find(c color.Color) (CatalogColor, bool) {
+	r, g, b, a := rgba(c)
+	for _, cc := range cat.Colors {
+		if r1, g1, b1, a1 := rgba(cc.Color); r == r1 && g == g1 && b == b1 && a == a1 {
+			return cc, true
+		}
+	}
+	return CatalogColor{}, false
+}
+
+// Snap returns the palette with each color replaced by the nearest
+// color of the catalog. The colors mapped to the same catalog color
+// are merged, so the returned palette can be shorter than pal.
+func (cat *Catalog) Snap(pal color.Palette) color.Palette {
+	var snapped color.Palette
+	used := map[string]bool{}
+	for _, c := range pal {
+		cc := cat.Nearest(c)
+		if !used[cc.Code] {
+			used[cc.Code] = true
+			snapped = append(snapped, cc.Color)
+		}
+	}
+	return snapped
+}
+
+// Reference returns the reference to the catalog color,
+// as written in the legend of a coding: "catalog code".
+func (cat *Catalog) Reference(cc CatalogColor) string {
+	return cat.Name + " " + cc.Code
+}
+
+// ReadCatalogCSV reads a catalog from r in the CSV format.
+// Each record is "code,name,color", where color is in any format
+// understood by ParseColor, or "code,name,r,g,b".
+// A first record starting with "code" is skipped as a header.
+func ReadCatalogCSV(r io.Reader, name string) (*Catalog, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.Comment = '#'
+	cr.TrimLeadingSpace = true
+
+	var colors []CatalogColor
+	for line := 1; ; line++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if line == 1 && strings.EqualFold(strings.TrimSpace(rec[0]), "code") {
+			continue
+		}
+
+		var c color.Color
+		switch len(rec) {
+		case 3:
+			c, err = ParseColor(strings.TrimSpace(rec[2]))
+		case 5:
+			var rgb [3]uint8
+			for j := range rgb {
+				var v int
+				v, err = strconv.Atoi(strings.TrimSpace(rec[2+j]))
+				if err == nil && (v < 0 || v > 255) {
+					err = fmt.Errorf("invalid component %d", v)
+				}
+				rgb[j] = uint8(v)
+			}
+			c = color.NRGBA{rgb[0], rgb[1], rgb[2], 255}
+		default:
+			err = fmt.Errorf("expecting 3 or 5 fields, found %d", len(rec))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: record %d: %w", name, line, err)
+		}
+		colors = append(colors, CatalogColor{strings.TrimSpace(rec[0]), strings.TrimSpace(rec[1]), c})
+	}
+	return newCatalogChecked(name, colors)
+}
+
+// ReadCatalogJSON reads a catalog from r in the JSON format:
+// an array of objects with the "code", "name" and "color" fields,
+// where color is in any format understood by ParseColor.
+func ReadCatalogJSON(r io.Reader, name string) (*Catalog, error) {
+	var recs []struct {
+		Code  string `json:"code"`
+		Name  string `json:"name"`
+		Color string `json:"color"`
+	}
+	if err := json.NewDecoder(r).Decode(&recs); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	var colors []CatalogColor
+	for j, rec := range recs {
+		c, err := ParseColor(rec.Color)
+		if err != nil {
+			return nil, fmt.Errorf("%s: color %d: %w", name, j+1, err)
+		}
+		colors = append(colors, CatalogColor{rec.Code, rec.Name, c})
+	}
+	return newCatalogChecked(name, colors)
+}
+
+func newCatalogChecked(name string, colors []CatalogColor) (*Catalog, error) {
+	if len(colors) == 0 {
+		return nil, fmt.Errorf("%s: empty catalog", name)
+	}
+	for _, c := range colors {
+		if c.Code == "" || strings.ContainsAny(c.Code, " \t()") {
+			return nil, fmt.Errorf("%s: invalid code %q", name, c.Code)
+		}
+	}
+	return NewCatalog(name, colors), nil
+}
+
+// LoadCatalog loads the catalog at path, in the CSV or JSON format
+// according to the extension of the file. The name of the catalog
+// is the name of the file without the extension.
+func LoadCatalog(path string) (*Catalog, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ext := filepath.Ext(path)
+	name := strings.TrimSuffix(filepath.Base(path), ext)
+	if name == "" || strings.ContainsAny(name, " \t()") {
+		return nil, fmt.Errorf("%s: invalid catalog name %q", path, name)
+	}
+	switch strings.ToLower(ext) {
+	case ".csv":
+		return ReadCatalogCSV(f, name)
+	case ".json":
+		return ReadCatalogJSON(f, name)
+	}
+	return nil, errors.New("unknown format of the catalog file " + path)
+}
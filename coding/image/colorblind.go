@@ -0,0 +1,109 @@
+package image
+
+import (
+	"fmt"
+	"image/color"
+)
+
+// Deficiency is a kind of color vision deficiency.
+type Deficiency int
+
+// The simulated color vision deficiencies.
+const (
+	Protanopia Deficiency = iota
+	Deuteranopia
+	Tritanopia
+)
+
+// Deficiencies are all the simulated color vision deficiencies.
+var Deficiencies = []Deficiency{Protanopia, Deuteranopia, Tritanopia}
+
+func (d Deficiency) String() string {
+	switch d {
+	case Protanopia:
+		return "protanopia"
+	case Deuteranopia:
+		return "deuteranopia"
+	case Tritanopia:
+		return "tritanopia"
+	}
+	return fmt.Sprintf("Deficiency(%d)", int(d))
+}
+
+// deficiencyMatrix contains the matrices of Machado, Oliveira and Fernandes
+// (2009), with severity 1, applied to the linear RGB components.
+var deficiencyMatrix = map[Deficiency][3][3]float64{
+	Protanopia: {
+		{0.152286, 1.052583, -0.204868},
+		{0.114503, 0.786281, 0.099216},
+		{-0.003882, -0.048116, 1.051998},
+	},
+	Deuteranopia: {
+		{0.367322, 0.860646, -0.227968},
+		{0.280085, 0.672501, 0.047413},
+		{-0.011820, 0.042940, 0.968881},
+	},
+	Tritanopia: {
+		{1.255528, -0.076749, -0.178779},
+		{-0.078411, 0.930809, 0.147602},
+		{0.004733, 0.691367, 0.303900},
+	},
+}
+
+// Simulate returns the color as seen by a person with the deficiency d.
+func Simulate(c color.Color, d Deficiency) color.Color {
+	m, ok := deficiencyMatrix[d]
+	if !ok {
+		return c
+	}
+	r, g, b := linearRGB(c)
+	_, _, _, a := rgba(c)
+	return color.NRGBA{
+		delinearize(m[0][0]*r + m[0][1]*g + m[0][2]*b),
+		delinearize(m[1][0]*r + m[1][1]*g + m[1][2]*b),
+		delinearize(m[2][0]*r + m[2][1]*g + m[2][2]*b),
+		a,
+	}
+}
+
+// ConfusablePair is a pair of colors of a palette that are hard
+// to distinguish for a person with a color vision deficiency.
+type ConfusablePair struct {
+	I, J       int        // indexes of the colors in the palette
+	Deficiency Deficiency // the deficiency
+	Distance   float64    // CIEDE2000 distance of the simulated colors
+}
+
+// ConfusablePairs returns the pairs of colors of the palette whose
+// simulated colors have a CIEDE2000 distance less than threshold,
+// for each deficiency. Pairs already indistinguishable with normal
+// vision are reported too. Fully transparent colors are skipped.
+func ConfusablePairs(pal color.Palette, threshold float64) []ConfusablePair {
+	var pairs []ConfusablePair
+
+	for _, d := range Deficiencies {
+		labs := make([]Lab, len(pal))
+		for j, c := range pal {
+			labs[j] = ToLab(Simulate(c, d))
+		}
+		for i := 0; i < len(pal); i++ {
+			if isTransparent(pal[i]) {
+				continue
+			}
+			for j := i + 1; j < len(pal); j++ {
+				if isTransparent(pal[j]) {
+					continue
+				}
+				if dist := DeltaE2000(labs[i], labs[j]); dist < threshold {
+					pairs = append(pairs, ConfusablePair{i, j, d, dist})
+				}
+			}
+		}
+	}
+	return pairs
+}
+
+func isTransparent(c color.Color) bool {
+	_, _, _, a := c.RGBA()
+	return a == 0
+}
@@ -3,7 +3,9 @@ package image
 import (
 	"fmt"
 	"image/color"
+	"math"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -74,8 +76,15 @@ var (
 	reRgb                []*regexp.Regexp
 	hex2colorname        map[string]string
 	colorname2customname map[string]string
+
+	// sorted color names, for a deterministic nearest color search
+	colornamesSorted []string
+	colornamesLab    []Lab
 )
 
+// TransparentName is the name of the fully transparent colors.
+const TransparentName = "trasparente"
+
 func init() {
 	// build the array of rgb regexp
 	reRgb = make([]*regexp.Regexp, len(patternRgb))
@@ -96,6 +105,14 @@ func init() {
 		colorname2customname[name] = custom
 	}
 
+	// build the list of named colors
+	colornamesSorted = append(colornamesSorted, colornames.Names...)
+	sort.Strings(colornamesSorted)
+	colornamesLab = make([]Lab, len(colornamesSorted))
+	for j, name := range colornamesSorted {
+		colornamesLab[j] = ToLab(colornames.Map[name])
+	}
+
 }
 
 func parsePerc(s string) (int, bool) {
@@ -351,3 +368,24 @@ func ToString(c color.Color) string {
 	}
 	return ToRGB(c)
 }
+
+// NearestColorName returns the name of the named color nearest
+// (by CIEDE2000 distance) to c. The custom name is returned
+// if the named color has one. Fully transparent colors
+// are named TransparentName.
+func NearestColorName(c color.Color) string {
+	if _, _, _, a := c.RGBA(); a == 0 {
+		return TransparentName
+	}
+	lab := ToLab(c)
+	best, dist := "", math.Inf(1)
+	for j, name := range colornamesSorted {
+		if d := DeltaE2000(lab, colornamesLab[j]); d < dist {
+			best, dist = name, d
+		}
+	}
+	if custom, ok := colorname2customname[best]; ok {
+		return custom
+	}
+	return best
+}
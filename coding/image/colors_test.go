@@ -1,8 +1,21 @@
 package image
 
 import (
+	"bytes"
+	"image"
 	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"math/rand"
+	"strings"
 	"testing"
+
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
 )
 
 func colorsEq(c1, c2 color.Color) bool {
@@ -183,3 +196,643 @@ func TestToHex(t *testing.T) {
 		}
 	}
 }
+
+func TestDeltaE2000(t *testing.T) {
+	// test data from G. Sharma, W. Wu, E. N. Dalal,
+	// "The CIEDE2000 Color-Difference Formula"
+	var testCases = []struct {
+		c1, c2   Lab
+		expected float64
+	}{
+		{Lab{50, 2.6772, -79.7751}, Lab{50, 0, -82.7485}, 2.0425},
+		{Lab{50, 0, 0}, Lab{50, -1, 2}, 2.3669},
+		{Lab{50, 2.49, -0.001}, Lab{50, -2.49, 0.0009}, 7.1792},
+		{Lab{50, 2.5, 0}, Lab{73, 25, -18}, 27.1492},
+		{Lab{60.2574, -34.0099, 36.2677}, Lab{60.4626, -34.1751, 39.4387}, 1.2644},
+		{Lab{2.0776, 0.0795, -1.135}, Lab{0.9033, -0.0636, -0.5514}, 0.9082},
+	}
+	for _, tc := range testCases {
+		actual := DeltaE2000(tc.c1, tc.c2)
+		if math.Abs(actual-tc.expected) > 1e-4 {
+			t.Errorf("Input %v, %v: expected %v, found %v", tc.c1, tc.c2, tc.expected, actual)
+		}
+	}
+}
+
+func TestPerceptualPalette(t *testing.T) {
+	red := color.NRGBA{255, 0, 0, 255}
+	red2 := color.NRGBA{250, 5, 5, 255}
+	green := color.NRGBA{0, 200, 0, 255}
+	blue := color.NRGBA{0, 0, 255, 255}
+
+	pal := PerceptualPalette(color.Palette{red, red2, green, blue}, 3)
+	if len(pal) != 3 || pal[0] != red {
+		t.Fatalf("Unexpected palette %v", pal)
+	}
+	for _, c := range pal {
+		if c == red2 {
+			t.Errorf("Expected %v to be discarded", red2)
+		}
+	}
+
+	// red and green are confused by protanopes and deuteranopes
+	pairs := ConfusablePairs(color.Palette{color.NRGBA{200, 60, 0, 255}, color.NRGBA{110, 110, 0, 255}}, 10)
+	if len(pairs) == 0 {
+		t.Errorf("Expected confusable pairs")
+	}
+}
+
+func TestCatalog(t *testing.T) {
+	const csvCatalog = `code,name,color
+310,Black,#000000
+321, Red, 199, 43, 59
+3865,Winter White,#f9f7f1
+`
+	cat, err := ReadCatalogCSV(strings.NewReader(csvCatalog), "dmc")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	jsonCatalog := `[{"code": "310", "name": "Black", "color": "#000"},
+		{"code": "321", "name": "Red", "color": "rgb(199,43,59)"},
+		{"code": "3865", "name": "Winter White", "color": "#f9f7f1"}]`
+	cat2, err := ReadCatalogJSON(strings.NewReader(jsonCatalog), "dmc")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	var testCases = []struct {
+		input    color.Color
+		expected string
+	}{
+		{color.NRGBA{10, 10, 10, 255}, "310"},
+		{color.NRGBA{255, 0, 0, 255}, "321"},
+		{color.NRGBA{255, 255, 255, 255}, "3865"},
+	}
+	for _, tc := range testCases {
+		for _, c := range []*Catalog{cat, cat2} {
+			if cc := c.Nearest(tc.input); cc.Code != tc.expected {
+				t.Errorf("Input %v: expected %q, found %q", tc.input, tc.expected, cc.Code)
+			}
+		}
+	}
+
+	pal := cat.Snap(color.Palette{color.Black, color.NRGBA{20, 20, 20, 255}, color.White})
+	if len(pal) != 2 {
+		t.Errorf("Expected 2 colors, found %d", len(pal))
+	}
+
+	for _, s := range []string{"", "310,Black", "310,Black,#zz0000", "3 10,Black,#000"} {
+		if _, err := ReadCatalogCSV(strings.NewReader(s), "dmc"); err == nil {
+			t.Errorf("Input %q: expected error, found nil", s)
+		}
+	}
+}
+
+func TestQuantize(t *testing.T) {
+	// an horizontal gradient from black to white
+	m := image.NewGray(image.Rect(0, 0, 64, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 64; x++ {
+			m.SetGray(x, y, color.Gray{uint8(x * 255 / 63)})
+		}
+	}
+	pal := color.Palette{color.Black, color.White}
+
+	for _, d := range []Dither{DitherNone, FloydSteinberg, Atkinson, Bayer2, Bayer4, Bayer8} {
+		if d2, err := ParseDither(d.String()); err != nil || d2 != d {
+			t.Errorf("Input %q: expected %v, found %v (%v)", d.String(), d, d2, err)
+		}
+
+		p := Quantize(m, pal, d)
+		// count the white pixels of the left and right halves
+		var left, right int
+		for y := 0; y < 8; y++ {
+			for x := 0; x < 64; x++ {
+				if p.ColorIndexAt(x, y) == 1 {
+					if x < 32 {
+						left++
+					} else {
+						right++
+					}
+				}
+			}
+		}
+		if d == DitherNone {
+			// flat bands
+			if left != 0 || right != 256 {
+				t.Errorf("Dither %v: expected 0 and 256 white pixels, found %d and %d", d, left, right)
+			}
+			continue
+		}
+		// the dithered halves mix the colors, the right one is lighter
+		if left == 0 || right == 256 || left >= right {
+			t.Errorf("Dither %v: unexpected %d and %d white pixels", d, left, right)
+		}
+	}
+
+	if _, err := ParseDither("random"); err == nil {
+		t.Errorf("Input %q: expected error, found nil", "random")
+	}
+}
+
+func TestToImageManyColors(t *testing.T) {
+	// an image of 30x30 pixels with 400 colors
+	var pal FixedPalette
+	for j := 0; j < 400; j++ {
+		pal = append(pal, color.NRGBA{uint8(j % 20 * 13), uint8(j / 20 * 13), uint8(j % 7 * 40), 255})
+	}
+	m := image.NewNRGBA(image.Rect(0, 0, 30, 30))
+	for j := 0; j < 900; j++ {
+		m.Set(j%30, j/30, pal[j%400])
+	}
+	opt := PalettedOptions{Width: 30, Height: 30, NumColors: 400, Sampler: SampleNearest, Extractor: pal}
+
+	if _, err := ToPaletted(m, &opt); err == nil {
+		t.Errorf("Expected error with %d colors, found nil", opt.NumColors)
+	}
+	for _, d := range []Dither{DitherNone, FloydSteinberg, Bayer4} {
+		opt.Dither = d
+		mm, err := ToImage(m, &opt)
+		if err != nil {
+			t.Fatalf("Dither %v: unexpected error: %s", d, err)
+		}
+		if _, ok := mm.(*image.Paletted); ok {
+			t.Errorf("Dither %v: expected a full color image, found a paletted one", d)
+		}
+		if d != DitherNone {
+			continue
+		}
+		for y := 0; y < 30; y++ {
+			for x := 0; x < 30; x++ {
+				if !colorsEq(mm.At(x, y), m.At(x, y)) {
+					t.Errorf("Pixel (%d,%d): expected %v, found %v", x, y, m.At(x, y), mm.At(x, y))
+				}
+			}
+		}
+	}
+}
+
+func TestPaletteExtractors(t *testing.T) {
+	// a block of red, and two smaller blocks of green and blue,
+	// with some noise
+	m := image.NewNRGBA(image.Rect(0, 0, 64, 10))
+	for x := 0; x < 64; x++ {
+		for y := 0; y < 10; y++ {
+			v := uint8((x + y) % 5)
+			switch {
+			case x < 32:
+				m.Set(x, y, color.NRGBA{250 - v, v, v, 255})
+			case x < 48:
+				m.Set(x, y, color.NRGBA{v, 250 - v, v, 255})
+			default:
+				m.Set(x, y, color.NRGBA{v, v, 250 - v, 255})
+			}
+		}
+	}
+	expected := color.Palette{color.NRGBA{255, 0, 0, 255}, color.NRGBA{0, 0, 255, 255}, color.NRGBA{0, 255, 0, 255}}
+
+	for _, ext := range []PaletteExtractor{MedianCutExtractor{}, KMeansExtractor{}} {
+		pal, err := ext.Extract(m, 3)
+		if err != nil {
+			t.Fatalf("%T: unexpected error: %s", ext, err)
+		}
+		if len(pal) != 3 {
+			t.Fatalf("%T: expected 3 colors, found %d", ext, len(pal))
+		}
+		// sorted by population, blue and green in the order of the boxes
+		for j, c := range pal {
+			if d := ColorDistance(c, expected[j]); d > 5 {
+				t.Errorf("%T: color %d: expected %v, found %v", ext, j, expected[j], c)
+			}
+		}
+	}
+
+	fp, err := ParseFixedPalette("#000; rgb(155, 155, 155) ;nero")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if pal, _ := fp.Extract(m, 2); len(pal) != 2 || !colorsEq(pal[1], color.NRGBA{155, 155, 155, 255}) {
+		t.Errorf("Unexpected fixed palette %v", pal)
+	}
+	if _, err := NewExtractor("octree"); err == nil {
+		t.Errorf("Input %q: expected error, found nil", "octree")
+	}
+}
+
+func TestAspect(t *testing.T) {
+	var testCases = []struct {
+		input      string
+		cols, rows int
+		cw, ch     int
+	}{
+		{"1:1", 40, 20, 10, 10},
+		{"5:4", 40, 25, 10, 8},
+		{"2", 40, 40, 10, 5},
+		{" 4 : 5 ", 40, 16, 10, 13},
+	}
+	r := image.Rect(0, 0, 400, 200)
+	for _, tc := range testCases {
+		a, err := ParseAspect(tc.input)
+		if err != nil {
+			t.Fatalf("Input %q: unexpected error: %s", tc.input, err)
+		}
+		if cols, rows := GridSize(r, tc.cols, a); cols != tc.cols || rows != tc.rows {
+			t.Errorf("Input %q: expected grid %dx%d, found %dx%d", tc.input, tc.cols, tc.rows, cols, rows)
+		}
+		if cw, ch := a.CellSize(10); cw != tc.cw || ch != tc.ch {
+			t.Errorf("Input %q: expected cell %dx%d, found %dx%d", tc.input, tc.cw, tc.ch, cw, ch)
+		}
+	}
+	for _, s := range []string{"", "5:0", "a:b", "-1:2"} {
+		if _, err := ParseAspect(s); err == nil {
+			t.Errorf("Input %q: expected error, found nil", s)
+		}
+	}
+}
+
+func TestSamplers(t *testing.T) {
+	// a row of gray pixels: 0, 0, 255, 90
+	src := image.NewGray(image.Rect(0, 0, 4, 1))
+	for x, v := range []uint8{0, 0, 255, 90} {
+		src.SetGray(x, 0, color.Gray{v})
+	}
+	var testCases = []struct {
+		name string
+		f    Footprint
+		gray uint8
+	}{
+		{"nearest", Footprint{0, 0, 3, 1}, 0},
+		{"nearest", Footprint{1.5, 0, 3, 1}, 255},
+		{"box", Footprint{0, 0, 3, 1}, 85},
+		{"box", Footprint{1.5, 0, 3, 1}, 170},
+		{"median", Footprint{0, 0, 3, 1}, 0},
+		{"median", Footprint{1, 0, 4, 1}, 90},
+		{"mode", Footprint{0, 0, 3, 1}, 0},
+		{"mode", Footprint{1.5, 0, 4, 1}, 255},
+		{"gaussian", Footprint{2, 0, 3, 1}, 230},
+	}
+	for _, tc := range testCases {
+		sampler, err := NewSampler(tc.name)
+		if err != nil {
+			t.Fatalf("Input %q: unexpected error: %s", tc.name, err)
+		}
+		c, err := sampler(src, tc.f)
+		if err != nil {
+			t.Errorf("Input %q %v: unexpected error: %s", tc.name, tc.f, err)
+			continue
+		}
+		if g := color.GrayModel.Convert(c).(color.Gray); g.Y != tc.gray {
+			t.Errorf("Input %q %v: expected gray %d, found %d", tc.name, tc.f, tc.gray, g.Y)
+		}
+	}
+	if _, err := SampleBox(src, Footprint{5, 0, 6, 1}); err == nil {
+		t.Errorf("Input %q: expected error, found nil", "box outside")
+	}
+
+	// any image type can be pixelated
+	grays := color.Palette{color.Gray{0}, color.Gray{60}, color.Gray{120}, color.Gray{180}}
+	images := map[string]draw.Image{
+		"gray":     image.NewGray(image.Rect(0, 0, 4, 2)),
+		"cmyk":     image.NewCMYK(image.Rect(0, 0, 4, 2)),
+		"paletted": image.NewPaletted(image.Rect(0, 0, 4, 2), grays),
+		"rgba64":   image.NewRGBA64(image.Rect(0, 0, 4, 2)),
+	}
+	for name, m := range images {
+		for y := 0; y < 2; y++ {
+			for x := 0; x < 4; x++ {
+				m.Set(x, y, grays[x])
+			}
+		}
+		for _, sampler := range SamplerNames {
+			fn, _ := NewSampler(sampler)
+			mm, err := Pixelate(m, fn, 2, 1)
+			if err != nil {
+				t.Errorf("Input %q %q: unexpected error: %s", name, sampler, err)
+				continue
+			}
+			if sampler != "box" {
+				continue
+			}
+			for x, v := range []uint8{30, 150} {
+				if g := color.GrayModel.Convert(mm.At(x, 0)).(color.Gray); g.Y != v {
+					t.Errorf("Input %q: expected gray %d at %d, found %d", name, v, x, g.Y)
+				}
+			}
+		}
+	}
+}
+
+func TestAverageColor(t *testing.T) {
+	pixels := func(cs ...color.Color) image.Image {
+		m := image.NewNRGBA(image.Rect(0, 0, len(cs), 1))
+		for x, c := range cs {
+			m.Set(x, 0, c)
+		}
+		return m
+	}
+	red := color.NRGBA{255, 0, 0, 255}
+	var testCases = []struct {
+		name     string
+		m        image.Image
+		linear   bool
+		expected color.NRGBA
+	}{
+		{"opaque", pixels(red, color.NRGBA{0, 0, 255, 255}), false, color.NRGBA{128, 0, 128, 255}},
+		{"half transparent", pixels(red, color.Transparent), false, color.NRGBA{255, 0, 0, 128}},
+		{"semi transparent", pixels(red, color.NRGBA{0, 0, 255, 85}), false, color.NRGBA{191, 0, 64, 170}},
+		{"transparent", pixels(color.Transparent, color.NRGBA{255, 255, 255, 0}), false, color.NRGBA{}},
+		{"linear", pixels(color.Black, color.White), true, color.NRGBA{188, 188, 188, 255}},
+		{"linear transparent", pixels(color.White, color.Transparent), true, color.NRGBA{255, 255, 255, 128}},
+	}
+	for _, tc := range testCases {
+		c := color.NRGBAModel.Convert(AverageColor(tc.m, tc.linear)).(color.NRGBA)
+		if c != tc.expected {
+			t.Errorf("Input %q: expected %v, found %v", tc.name, tc.expected, c)
+		}
+	}
+
+	// the transparent background is mapped to the transparent color
+	m := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	draw.Draw(m, image.Rect(2, 2, 6, 6), image.NewUniform(red), image.Point{}, draw.Src)
+	opt := PalettedOptions{Width: 4, Height: 4, NumColors: 2, Extractor: FixedPalette{red}}
+	imgpal, err := ToPaletted(m, &opt)
+	if err != nil {
+		t.Fatalf("Input %q: unexpected error: %s", "sprite", err)
+	}
+	for _, p := range []image.Point{{0, 0}, {3, 0}, {0, 3}} {
+		if c := imgpal.At(p.X, p.Y); !isTransparent(c) {
+			t.Errorf("Input %q: expected transparent at %v, found %v", "sprite", p, c)
+		}
+	}
+	if c := imgpal.At(1, 1); !colorsEq(c, red) {
+		t.Errorf("Input %q: expected %v at (1,1), found %v", "sprite", red, c)
+	}
+}
+
+func TestThickenOutlines(t *testing.T) {
+	// a yellow square with a one pixel black outline,
+	// on a transparent background
+	black, yellow := color.NRGBA{0, 0, 0, 255}, color.NRGBA{250, 220, 30, 255}
+	src := image.NewNRGBA(image.Rect(0, 0, 12, 12))
+	draw.Draw(src, image.Rect(1, 1, 11, 11), image.NewUniform(black), image.Point{}, draw.Src)
+	draw.Draw(src, image.Rect(2, 2, 10, 10), image.NewUniform(yellow), image.Point{}, draw.Src)
+
+	for _, name := range []string{"box", "detail"} {
+		sampler, _ := NewSampler(name)
+		m, err := Pixelate(src, sampler, 4, 4)
+		if err != nil {
+			t.Fatalf("Input %q: unexpected error: %s", name, err)
+		}
+		if err = ThickenOutlines(src, m.(draw.Image), DefaultOutlineOptions); err != nil {
+			t.Fatalf("Input %q: unexpected error: %s", name, err)
+		}
+		for y := 0; y < 4; y++ {
+			for x := 0; x < 4; x++ {
+				expected := black
+				if x > 0 && x < 3 && y > 0 && y < 3 {
+					expected = yellow
+				}
+				if c := color.NRGBAModel.Convert(m.At(x, y)); c != expected {
+					t.Errorf("Input %q: expected %v at (%d,%d), found %v", name, expected, x, y, c)
+				}
+			}
+		}
+	}
+}
+
+func TestUnscale(t *testing.T) {
+	// a pixel art image of 12x8 pixels with 4 colors
+	pal := color.Palette{
+		color.NRGBA{255, 0, 0, 255},
+		color.NRGBA{0, 128, 0, 255},
+		color.NRGBA{0, 0, 255, 255},
+		color.NRGBA{},
+	}
+	art := image.NewPaletted(image.Rect(0, 0, 12, 8), pal)
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 12; x++ {
+			art.SetColorIndex(x, y, uint8((x*x+3*y+x*y/2)%len(pal)))
+		}
+	}
+	// upscale returns the art upscaled by the factor s, with nearest
+	// neighbour, cutting cut pixels at the left and at the top.
+	upscale := func(s float64, cut int) image.Image {
+		w, h := int(12*s+0.5)-cut, int(8*s+0.5)-cut
+		m := image.NewNRGBA(image.Rect(0, 0, w, h))
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				m.Set(x, y, art.At(int(float64(x+cut)/s), int(float64(y+cut)/s)))
+			}
+		}
+		return m
+	}
+
+	var testCases = []struct {
+		s      float64
+		cut    int
+		x0, y0 int // first cell of the art in the unscaled image
+	}{
+		{1, 0, 0, 0},
+		{4, 0, 0, 0},
+		{5, 1, 0, 0},
+		{5, 3, 1, 1},
+		{2.5, 0, 0, 0},
+		{3.5, 1, 0, 0},
+		{3.5, 3, 1, 1},
+	}
+	for _, tc := range testCases {
+		m := upscale(tc.s, tc.cut)
+		imgpal, g, err := Unscale(m)
+		if err != nil {
+			t.Errorf("Input %g/%d: unexpected error: %s", tc.s, tc.cut, err)
+			continue
+		}
+		if math.Abs(g.CellW-tc.s) > 0.05 || math.Abs(g.CellH-tc.s) > 0.05 {
+			t.Errorf("Input %g/%d: expected cells of %g pixels, found %v", tc.s, tc.cut, tc.s, g)
+		}
+		if cols, rows := 12-tc.x0, 8-tc.y0; g.Cols != cols || g.Rows != rows {
+			t.Errorf("Input %g/%d: expected %dx%d cells, found %v", tc.s, tc.cut, cols, rows, g)
+			continue
+		}
+		for y := 0; y < g.Rows; y++ {
+			for x := 0; x < g.Cols; x++ {
+				if c1, c2 := imgpal.At(x, y), art.At(x+tc.x0, y+tc.y0); !colorsEq(c1, c2) {
+					t.Errorf("Input %g/%d: expected %v at (%d,%d), found %v", tc.s, tc.cut, c2, x, y, c1)
+				}
+			}
+		}
+	}
+
+	// noisy images, not upscaled, with edges between all the pixels
+	for seed := int64(0); seed < 20; seed++ {
+		r := rand.New(rand.NewSource(seed))
+		m := image.NewNRGBA(image.Rect(0, 0, 40, 30))
+		for j := range m.Pix {
+			m.Pix[j] = uint8(r.Intn(2) * 255)
+			if j%4 == 3 {
+				m.Pix[j] = 255
+			}
+		}
+		if g, err := DetectGrid(m); err != nil || g.CellW != 1 || g.CellH != 1 || g.Cols != 40 || g.Rows != 30 {
+			t.Errorf("Noise %d: expected 40x30 cells of 1x1 pixels, found %v (%v)", seed, g, err)
+		}
+	}
+}
+
+func TestZoom(t *testing.T) {
+	red := color.NRGBA{255, 0, 0, 255}
+	pal := color.Palette{red, color.Transparent}
+	m := image.NewPaletted(image.Rect(0, 0, 3, 2), pal)
+	m.SetColorIndex(1, 0, 1)
+
+	opt := ZoomOptions{
+		CellW:      2.5,
+		CellH:      2,
+		GridWidth:  1,
+		MajorEvery: 2,
+		MajorWidth: 2,
+		Border:     1,
+		Background: color.White,
+	}
+	zoomed, l, err := ZoomWith(m, opt)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	zp, ok := zoomed.(*image.Paletted)
+	if !ok {
+		t.Fatalf("expected *image.Paletted, found %T", zoomed)
+	}
+	// the palette of the image comes first
+	if len(zp.Palette) < len(pal) || !colorsEq(zp.Palette[0], red) {
+		t.Errorf("expected the palette %v first, found %v", pal, zp.Palette)
+	}
+	if w, h := l.Size(); w != 13 || h != 7 || zp.Bounds() != image.Rect(0, 0, 13, 7) {
+		t.Errorf("expected size 13x7, found %dx%d, bounds %v", w, h, zp.Bounds())
+	}
+
+	var testCases = []struct {
+		px, py int
+		x, y   int
+		ok     bool
+		c      color.Color
+	}{
+		{0, 0, 0, 0, false, color.Black},     // border
+		{1, 1, 0, 0, true, red},              // cell (0,0)
+		{3, 2, 0, 0, true, red},              // cell (0,0)
+		{4, 1, 0, 0, false, color.Gray{160}}, // gridline
+		{5, 1, 1, 0, true, color.White},      // transparent cell (1,0)
+		{7, 1, 0, 0, false, color.Black},     // major gridline
+		{9, 4, 2, 1, true, red},              // cell (2,1)
+		{11, 5, 2, 1, true, red},             // cell (2,1)
+		{12, 6, 0, 0, false, color.Black},    // border
+	}
+	for _, tc := range testCases {
+		x, y, ok := l.CellAt(tc.px, tc.py)
+		if ok != tc.ok || (ok && (x != tc.x || y != tc.y)) {
+			t.Errorf("Input (%d,%d): expected cell (%d,%d) %v, found (%d,%d) %v", tc.px, tc.py, tc.x, tc.y, tc.ok, x, y, ok)
+		}
+		if c := zp.At(tc.px, tc.py); !colorsEq(c, tc.c) {
+			t.Errorf("Input (%d,%d): expected color %v, found %v", tc.px, tc.py, tc.c, c)
+		}
+	}
+
+	if _, err := Zoom(m, 0, 1); err == nil {
+		t.Errorf("Input %q: expected error, found nil", "zoom 0")
+	}
+}
+
+func TestDecode(t *testing.T) {
+	m := image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	draw.Draw(m, m.Rect, image.NewUniform(color.White), image.Point{}, draw.Src)
+	m.Set(0, 0, color.NRGBA{255, 0, 0, 255})
+	m.Set(2, 1, color.NRGBA{0, 0, 255, 255})
+
+	encoders := map[string]func(w io.Writer, m image.Image) error{
+		"png":  png.Encode,
+		"gif":  func(w io.Writer, m image.Image) error { return gif.Encode(w, m, nil) },
+		"bmp":  bmp.Encode,
+		"tiff": func(w io.Writer, m image.Image) error { return tiff.Encode(w, m, nil) },
+	}
+	for format, encode := range encoders {
+		var buf bytes.Buffer
+		if err := encode(&buf, m); err != nil {
+			t.Fatalf("Format %s: unexpected error: %s", format, err)
+		}
+		m2, found, err := Decode(&buf)
+		if err != nil {
+			t.Errorf("Format %s: unexpected error: %s", format, err)
+			continue
+		}
+		if found != format {
+			t.Errorf("Format %s: found format %s", format, found)
+		}
+		for _, p := range []image.Point{{0, 0}, {1, 0}, {2, 1}} {
+			if !colorsEq(m2.At(p.X, p.Y), m.At(p.X, p.Y)) {
+				t.Errorf("Format %s: pixel %v: expected %v, found %v", format, p, m.At(p.X, p.Y), m2.At(p.X, p.Y))
+			}
+		}
+	}
+	if _, _, err := Decode(strings.NewReader("not an image")); err == nil {
+		t.Errorf("Expected error, found nil")
+	}
+}
+
+// exifJpeg returns the jpeg image m with the EXIF orientation o.
+func exifJpeg(t *testing.T, m image.Image, o uint16, bigEndian bool) []byte {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, m, nil); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	// a TIFF header and an IFD with the orientation tag only
+	tiff := []byte{'I', 'I', 42, 0, 8, 0, 0, 0, 1, 0, 0x12, 0x01, 3, 0, 1, 0, 0, 0, byte(o), 0, 0, 0, 0, 0, 0, 0}
+	if bigEndian {
+		tiff = []byte{'M', 'M', 0, 42, 0, 0, 0, 8, 0, 1, 0x01, 0x12, 0, 3, 0, 0, 0, 1, 0, byte(o), 0, 0, 0, 0, 0, 0}
+	}
+	seg := append([]byte("Exif\x00\x00"), tiff...)
+	n := len(seg) + 2
+	app1 := append([]byte{0xff, 0xe1, byte(n >> 8), byte(n)}, seg...)
+	data := buf.Bytes()
+	return append(append(append([]byte{}, data[:2]...), app1...), data[2:]...)
+}
+
+func TestExifOrientation(t *testing.T) {
+	// a dark left half and a light right half
+	m := image.NewGray(image.Rect(0, 0, 16, 8))
+	for y := 0; y < 8; y++ {
+		for x := 8; x < 16; x++ {
+			m.SetGray(x, y, color.Gray{255})
+		}
+	}
+	testCases := []struct {
+		o     uint16
+		w, h  int
+		dark  image.Point
+		light image.Point
+	}{
+		{1, 16, 8, image.Pt(2, 4), image.Pt(13, 4)},
+		{2, 16, 8, image.Pt(13, 4), image.Pt(2, 4)},
+		{3, 16, 8, image.Pt(13, 4), image.Pt(2, 4)},
+		{6, 8, 16, image.Pt(4, 2), image.Pt(4, 13)},
+		{8, 8, 16, image.Pt(4, 13), image.Pt(4, 2)},
+	}
+	for _, tc := range testCases {
+		for _, bigEndian := range []bool{false, true} {
+			m2, format, err := Decode(bytes.NewReader(exifJpeg(t, m, tc.o, bigEndian)))
+			if err != nil {
+				t.Fatalf("Orientation %d: unexpected error: %s", tc.o, err)
+			}
+			if format != "jpeg" {
+				t.Errorf("Orientation %d: expected format jpeg, found %s", tc.o, format)
+			}
+			if b := m2.Bounds(); b.Dx() != tc.w || b.Dy() != tc.h {
+				t.Errorf("Orientation %d: expected size %dx%d, found %v", tc.o, tc.w, tc.h, b)
+				continue
+			}
+			d := color.GrayModel.Convert(m2.At(tc.dark.X, tc.dark.Y)).(color.Gray)
+			l := color.GrayModel.Convert(m2.At(tc.light.X, tc.light.Y)).(color.Gray)
+			if d.Y > 64 || l.Y < 192 {
+				t.Errorf("Orientation %d: expected dark %v and light %v, found %d and %d", tc.o, tc.dark, tc.light, d.Y, l.Y)
+			}
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package image
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+)
+
+// Dither is the strategy used to map the colors of an image
+// to the colors of a palette.
+type Dither int
+
+// The dithering strategies.
+const (
+	// DitherNone maps each pixel to the nearest color of the palette.
+	DitherNone Dither = iota
+	// FloydSteinberg diffuses the error to the 4 next pixels.
+	FloydSteinberg
+	// Atkinson diffuses 3/4 of the error to the 6 next pixels:
+	// it keeps more contrast than FloydSteinberg.
+	Atkinson
+	// Bayer2, Bayer4 and Bayer8 use an ordered threshold matrix
+	// of the given side: they give a regular pattern.
+	Bayer2
+	Bayer4
+	Bayer8
+)
+
+var ditherNames = map[Dither]string{
+	DitherNone:     "none",
+	FloydSteinberg: "floyd-steinberg",
+	Atkinson:       "atkinson",
+	Bayer2:         "bayer2",
+	Bayer4:         "bayer4",
+	Bayer8:         "bayer8",
+}
+
+func (d Dither) String() string {
+	if s, ok := ditherNames[d]; ok {
+		return s
+	}
+	return fmt.Sprintf("Dither(%d)", int(d))
+}
+
+// ParseDither returns the dithering strategy with the given name:
+// "none", "floyd-steinberg", "atkinson", "bayer2", "bayer4" or "bayer8".
+func ParseDither(s string) (Dither, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for d, name := range ditherNames {
+		if s == name {
+			return d, nil
+		}
+	}
+	return DitherNone, fmt.Errorf("unknown dithering %q", s)
+}
+
+// diffusion is a fraction of the quantization error
+// given to the pixel at (dx, dy) from the current one.
+type diffusion struct {
+	dx, dy int
+	w      float64
+}
+
+var diffusions = map[Dither][]diffusion{
+	FloydSteinberg: {
+		{1, 0, 7.0 / 16}, {-1, 1, 3.0 / 16}, {0, 1, 5.0 / 16}, {1, 1, 1.0 / 16},
+	},
+	Atkinson: {
+		{1, 0, 1.0 / 8}, {2, 0, 1.0 / 8}, {-1, 1, 1.0 / 8},
+		{0, 1, 1.0 / 8}, {1, 1, 1.0 / 8}, {0, 2, 1.0 / 8},
+	},
+}
+
+// Quantize returns the paletted image of m, with the colors
+// mapped to the palette using the dithering strategy d.
+// If the palette has a fully transparent color, the pixels more
+// than half transparent are mapped to it, and the others are
+// considered opaque. The palette can have at most 256 colors:
+// see QuantizeImage for larger palettes.
+func Quantize(m image.Image, pal color.Palette, d Dither) *image.Paletted {
+	dst := image.NewPaletted(m.Bounds(), pal)
+	quantizeTo(m, pal, d, func(x, y, idx int) {
+		dst.SetColorIndex(x, y, uint8(idx))
+	})
+	return dst
+}
+
+// QuantizeImage is like Quantize, but the palette can have any number
+// of colors: if they are more than 256, the returned image is not
+// a *image.Paletted but a *image.NRGBA.
+func QuantizeImage(m image.Image, pal color.Palette, d Dither) image.Image {
+	if len(pal) <= 256 {
+		return Quantize(m, pal, d)
+	}
+	dst := image.NewNRGBA(m.Bounds())
+	quantizeTo(m, pal, d, func(x, y, idx int) {
+		dst.Set(x, y, pal[idx])
+	})
+	return dst
+}
+
+// quantizeTo maps the colors of m to the palette using the dithering
+// strategy d, calling set with the palette index of each pixel.
+func quantizeTo(m image.Image, pal color.Palette, d Dither, set func(x, y, idx int)) {
+	for _, c := range pal {
+		if isTransparent(c) {
+			m = alphaThreshold{m}
+			break
+		}
+	}
+	switch d {
+	case FloydSteinberg, Atkinson:
+		diffuseError(m, pal, diffusions[d], set)
+	case Bayer2:
+		orderedDither(m, pal, 2, set)
+	case Bayer4:
+		orderedDither(m, pal, 4, set)
+	case Bayer8:
+		orderedDither(m, pal, 8, set)
+	default:
+		b := m.Bounds()
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				set(x, y, pal.Index(m.At(x, y)))
+			}
+		}
+	}
+}
+
+// alphaThreshold is an image whose pixels are made fully transparent,
+// if more than half transparent, or else fully opaque.
+type alphaThreshold struct {
+	image.Image
+}
+
+func (m alphaThreshold) ColorModel() color.Model { return color.NRGBAModel }
+
+func (m alphaThreshold) At(x, y int) color.Color {
+	c := color.NRGBAModel.Convert(m.Image.At(x, y)).(color.NRGBA)
+	if c.A < 128 {
+		return color.NRGBA{}
+	}
+	c.A = 255
+	return c
+}
+
+// hasTransparent reports whether the image has pixels
+// more than half transparent.
+func hasTransparent(m image.Image) bool {
+	b := m.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if _, _, _, a := m.At(x, y).RGBA(); a < 0x8000 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// clamp8 returns v rounded and clamped to the range of a color component.
+func clamp8(v float64) uint8 {
+	if v <= 0 {
+		return 0
+	}
+	if v >= 255 {
+		return 255
+	}
+	return uint8(v + 0.5)
+}
+
+// diffuseError quantizes the image, diffusing the quantization error
+// of each pixel to the next ones.
+func diffuseError(m image.Image, pal color.Palette, diff []diffusion, set func(x, y, idx int)) {
+	b := m.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	// the color components of the pixels, with the diffused error
+	buf := make([][3]float64, w*h)
+	alpha := make([]uint8, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			r, g, bl, a := rgba(m.At(b.Min.X+x, b.Min.Y+y))
+			buf[y*w+x] = [3]float64{float64(r), float64(g), float64(bl)}
+			alpha[y*w+x] = a
+		}
+	}
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := buf[y*w+x]
+			idx := pal.Index(color.NRGBA{clamp8(c[0]), clamp8(c[1]), clamp8(c[2]), alpha[y*w+x]})
+			set(b.Min.X+x, b.Min.Y+y, idx)
+			if alpha[y*w+x] == 0 {
+				// the transparent pixels have no error to diffuse
+				continue
+			}
+
+			r, g, bl, _ := rgba(pal[idx])
+			e := [3]float64{c[0] - float64(r), c[1] - float64(g), c[2] - float64(bl)}
+			for _, d := range diff {
+				xx, yy := x+d.dx, y+d.dy
+				if xx < 0 || xx >= w || yy >= h {
+					continue
+				}
+				p := &buf[yy*w+xx]
+				for j := range p {
+					p[j] += e[j] * d.w
+				}
+			}
+		}
+	}
+}
+
+// bayerMatrix returns the Bayer threshold matrix of side n,
+// a power of 2, with the values from 0 to n*n-1.
+func bayerMatrix(n int) [][]int {
+	if n <= 1 {
+		return [][]int{{0}}
+	}
+	h := n / 2
+	sub := bayerMatrix(h)
+	m := make([][]int, n)
+	for y := range m {
+		m[y] = make([]int, n)
+		for x := range m[y] {
+			v := 4 * sub[y%h][x%h]
+			switch {
+			case y < h && x >= h:
+				v += 2
+			case y >= h && x < h:
+				v += 3
+			case y >= h && x >= h:
+				v++
+			}
+			m[y][x] = v
+		}
+	}
+	return m
+}
+
+// paletteSpread returns the mean distance, in RGB space,
+// between each color of the palette and the nearest other color.
+func paletteSpread(pal color.Palette) float64 {
+	if len(pal) < 2 {
+		return 0
+	}
+	var sum float64
+	for i, c1 := range pal {
+		r1, g1, b1, _ := rgba(c1)
+		min := math.Inf(1)
+		for j, c2 := range pal {
+			if i == j {
+				continue
+			}
+			r2, g2, b2, _ := rgba(c2)
+			dr, dg, db := float64(r1)-float64(r2), float64(g1)-float64(g2), float64(b1)-float64(b2)
+			if d := math.Sqrt(dr*dr + dg*dg + db*db); d < min {
+				min = d
+			}
+		}
+		sum += min
+	}
+	return sum / float64(len(pal))
+}
+
+// orderedDither quantizes the image adding to each pixel the threshold
+// of the Bayer matrix of side n, scaled to the spread of the palette.
+func orderedDither(m image.Image, pal color.Palette, n int, set func(x, y, idx int)) {
+	b := m.Bounds()
+	matrix := bayerMatrix(n)
+	spread := paletteSpread(pal)
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			t := spread * ((float64(matrix[(y-b.Min.Y)%n][(x-b.Min.X)%n])+0.5)/float64(n*n) - 0.5)
+			r, g, bl, a := rgba(m.At(x, y))
+			c := color.NRGBA{clamp8(float64(r) + t), clamp8(float64(g) + t), clamp8(float64(bl) + t), a}
+			set(x, y, pal.Index(c))
+		}
+	}
+}
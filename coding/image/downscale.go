@@ -0,0 +1,160 @@
+package image
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// maxRGBDistance is the distance between black and white in RGB space.
+var maxRGBDistance = math.Sqrt(3 * 255 * 255)
+
+// DetailSampler returns a sampler computing the mean color of the
+// pixels covered by the cell, each weighted by its distance from the
+// mean color of the cell raised to lambda: the pixels that differ
+// from their surroundings, like thin lines and small features, count
+// more than the flat areas. It is the detail-preserving downscaling
+// of Weber et al. (2016); with lambda 0 it is the same as SampleBox.
+func DetailSampler(lambda float64) Sampler {
+	return func(m image.Image, f Footprint) (color.Color, error) {
+		mean, err := SampleBox(m, f)
+		if err != nil {
+			return nil, err
+		}
+		mr, mg, mb, _ := rgba(mean)
+		var s colorSum
+		f.each(m.Bounds(), func(x, y int, w float64) {
+			c := m.At(x, y)
+			r, g, b, _ := rgba(c)
+			dr, dg, db := float64(r)-float64(mr), float64(g)-float64(mg), float64(b)-float64(mb)
+			d := math.Sqrt(dr*dr+dg*dg+db*db) / maxRGBDistance
+			s.add(c, w*math.Pow(d, lambda))
+		})
+		if s.w == 0 {
+			// a flat cell
+			return mean, nil
+		}
+		return s.color(), nil
+	}
+}
+
+// OutlineOptions are the options of ThickenOutlines.
+type OutlineOptions struct {
+	// Contrast is the minimum difference of lightness (CIE L*, from 0
+	// to 100) between a pixel and the mean of the opaque pixels of its
+	// cell, for the pixel to be part of an outline.
+	Contrast float64
+	// Coverage is the minimum fraction of the area of a cell covered
+	// by the outline (or by opaque pixels, for the transparent cells)
+	// for the cell to take the color of the outline.
+	Coverage float64
+}
+
+// DefaultOutlineOptions are the default options of ThickenOutlines:
+// they keep the one pixel outlines of a sprite reduced up to 5 times.
+var DefaultOutlineOptions = OutlineOptions{
+	Contrast: 35,
+	Coverage: 0.2,
+}
+
+// ThickenOutlines restores in the pixelated image m the outlines of the
+// source image src, that the sampling can average away. The cells of m
+// are mapped to src as done by Pixelate.
+//
+// A cell takes the mean color of its outline pixels, the pixels darker
+// than the mean of the cell, if they cover enough of the cell area.
+// A transparent cell takes the main color of its opaque pixels, if they
+// cover enough of the cell area, so that the silhouette is kept.
+func ThickenOutlines(src image.Image, m draw.Image, opt OutlineOptions) error {
+	sb, b := src.Bounds(), m.Bounds()
+	if b.Empty() || sb.Dx() < b.Dx() || sb.Dy() < b.Dy() {
+		return errors.New("ThickenOutlines: the pixelated image is bigger than the source image")
+	}
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			f := cellFootprint(sb, b.Dx(), b.Dy(), x, y)
+			if c, ok := outlineColor(src, f, opt); ok {
+				m.Set(b.Min.X+x, b.Min.Y+y, c)
+				continue
+			}
+			if _, _, _, a := m.At(b.Min.X+x, b.Min.Y+y).RGBA(); a >= 0x8000 {
+				continue
+			}
+			if c, ok := silhouetteColor(src, f, opt); ok {
+				m.Set(b.Min.X+x, b.Min.Y+y, c)
+			}
+		}
+	}
+	return nil
+}
+
+// outlineColor returns the opaque mean color of the outline pixels
+// of the cell, if they cover enough of the cell area.
+func outlineColor(src image.Image, f Footprint, opt OutlineOptions) (color.Color, bool) {
+	type pixel struct {
+		c color.Color
+		l float64
+		w float64
+	}
+	var pixels []pixel
+	var sumL, sumW float64
+	f.each(src.Bounds(), func(x, y int, w float64) {
+		c := src.At(x, y)
+		if _, _, _, a := c.RGBA(); a < 0x8000 {
+			return
+		}
+		l := ToLab(c).L
+		pixels = append(pixels, pixel{c, l, w})
+		sumL += l * w
+		sumW += w
+	})
+	if sumW == 0 {
+		return nil, false
+	}
+	mean := sumL / sumW
+
+	var s colorSum
+	for _, p := range pixels {
+		if p.l <= mean-opt.Contrast {
+			s.add(opaque(p.c), p.w)
+		}
+	}
+	if s.w == 0 || s.w < opt.Coverage*(f.X1-f.X0)*(f.Y1-f.Y0) {
+		return nil, false
+	}
+	return s.color(), true
+}
+
+// silhouetteColor returns the opaque color covering the largest area
+// of the cell, if the opaque pixels cover enough of the cell area.
+// At the border of a sprite, it is usually the color of the outline.
+func silhouetteColor(src image.Image, f Footprint, opt OutlineOptions) (color.Color, bool) {
+	areas := map[color.NRGBA]float64{}
+	var best color.NRGBA
+	var bestArea, total float64
+	f.each(src.Bounds(), func(x, y int, w float64) {
+		c := color.NRGBAModel.Convert(src.At(x, y)).(color.NRGBA)
+		if c.A < 128 {
+			return
+		}
+		c.A = 255
+		areas[c] += w
+		total += w
+		if areas[c] > bestArea {
+			best, bestArea = c, areas[c]
+		}
+	})
+	if total == 0 || total < opt.Coverage*(f.X1-f.X0)*(f.Y1-f.Y0) {
+		return nil, false
+	}
+	return best, true
+}
+
+// opaque returns the color made fully opaque.
+func opaque(c color.Color) color.Color {
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	n.A = 255
+	return n
+}
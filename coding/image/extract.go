@@ -0,0 +1,328 @@
+package image
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/Nykakin/quantize"
+)
+
+// PaletteExtractor extracts from an image a palette
+// of at most n colors, sorted by population.
+type PaletteExtractor interface {
+	Extract(m image.Image, n int) (color.Palette, error)
+}
+
+// VibrantExtractor extracts the palette with the vibrant package.
+type VibrantExtractor struct{}
+
+// Extract implements PaletteExtractor.
+func (VibrantExtractor) Extract(m image.Image, n int) (color.Palette, error) {
+	return getPal(m, n), nil
+}
+
+// HierarchicalExtractor extracts the palette with the hierarchical
+// quantizer of the quantize package.
+type HierarchicalExtractor struct{}
+
+// Extract implements PaletteExtractor.
+func (HierarchicalExtractor) Extract(m image.Image, n int) (color.Palette, error) {
+	colors, err := quantize.NewHierarhicalQuantizer().Quantize(m, n)
+	if err != nil {
+		return nil, err
+	}
+	pal := make(color.Palette, len(colors))
+	for j, c := range colors {
+		pal[j] = c
+	}
+	return pal, nil
+}
+
+// MedianCutExtractor extracts the palette with the median cut
+// algorithm: the box of colors with the most pixels is split
+// at the median of its widest component, until there are n boxes.
+type MedianCutExtractor struct{}
+
+// Extract implements PaletteExtractor.
+func (MedianCutExtractor) Extract(m image.Image, n int) (color.Palette, error) {
+	boxes := medianCut(histogram(m), n)
+	clusters := make([]cluster, len(boxes))
+	for j, b := range boxes {
+		for _, p := range b {
+			clusters[j].add(p)
+		}
+	}
+	return clusterPalette(clusters), nil
+}
+
+// KMeansExtractor extracts the palette clustering the colors
+// with the k-means algorithm in the CIE L*a*b* color space.
+// The clusters are initialized by the median cut algorithm.
+type KMeansExtractor struct {
+	// Iterations is the maximum number of iterations;
+	// if 0, 20 iterations are done.
+	Iterations int
+}
+
+// Extract implements PaletteExtractor.
+func (km KMeansExtractor) Extract(m image.Image, n int) (color.Palette, error) {
+	iterations := km.Iterations
+	if iterations <= 0 {
+		iterations = 20
+	}
+	pixels := histogram(m)
+	labs := make([]Lab, len(pixels))
+	for j, p := range pixels {
+		labs[j] = ToLab(p.c)
+	}
+
+	// initial centroids
+	var centroids []Lab
+	for _, b := range medianCut(pixels, n) {
+		var c cluster
+		for _, p := range b {
+			c.add(p)
+		}
+		centroids = append(centroids, ToLab(c.color()))
+	}
+
+	assign := make([]int, len(pixels))
+	for it := 0; it < iterations; it++ {
+		changed := false
+		for j, l := range labs {
+			best, bestDist := 0, math.Inf(1)
+			for k, c := range centroids {
+				dl, da, db := l.L-c.L, l.A-c.A, l.B-c.B
+				if d := dl*dl + da*da + db*db; d < bestDist {
+					best, bestDist = k, d
+				}
+			}
+			if assign[j] != best {
+				assign[j] = best
+				changed = true
+			}
+		}
+		if !changed && it > 0 {
+			break
+		}
+		// move the centroids to the mean of their colors
+		sums := make([]Lab, len(centroids))
+		counts := make([]float64, len(centroids))
+		for j, l := range labs {
+			w := float64(pixels[j].n)
+			k := assign[j]
+			sums[k].L += w * l.L
+			sums[k].A += w * l.A
+			sums[k].B += w * l.B
+			counts[k] += w
+		}
+		for k := range centroids {
+			if counts[k] > 0 {
+				centroids[k] = Lab{sums[k].L / counts[k], sums[k].A / counts[k], sums[k].B / counts[k]}
+			}
+		}
+	}
+
+	// the color of a cluster is the mean of its colors
+	clusters := make([]cluster, len(centroids))
+	for j, p := range pixels {
+		clusters[assign[j]].add(p)
+	}
+	return clusterPalette(clusters), nil
+}
+
+// FixedPalette is a palette given by the user:
+// it is extracted as is, whatever the image.
+type FixedPalette color.Palette
+
+// Extract implements PaletteExtractor. The first n colors are returned.
+func (fp FixedPalette) Extract(m image.Image, n int) (color.Palette, error) {
+	if len(fp) == 0 {
+		return nil, fmt.Errorf("empty fixed palette")
+	}
+	if n > 0 && n < len(fp) {
+		fp = fp[:n]
+	}
+	return append(color.Palette{}, fp...), nil
+}
+
+// ParseFixedPalette parses the colors of a fixed palette,
+// separated by ";", in any format understood by ParseColor.
+func ParseFixedPalette(s string) (FixedPalette, error) {
+	var fp FixedPalette
+	for _, cs := range strings.Split(s, ";") {
+		if cs = strings.TrimSpace(cs); cs == "" {
+			continue
+		}
+		c, err := ParseColor(cs)
+		if err != nil {
+			return nil, err
+		}
+		fp = append(fp, c)
+	}
+	if len(fp) == 0 {
+		return nil, fmt.Errorf("empty fixed palette")
+	}
+	return fp, nil
+}
+
+// ExtractorNames are the names of the palette extractors
+// returned by NewExtractor.
+var ExtractorNames = []string{"vibrant", "hierarchical", "mediancut", "kmeans"}
+
+// NewExtractor returns the palette extractor with the given name.
+func NewExtractor(name string) (PaletteExtractor, error) {
+	switch strings.ToLower(name) {
+	case "vibrant":
+		return VibrantExtractor{}, nil
+	case "hierarchical":
+		return HierarchicalExtractor{}, nil
+	case "mediancut":
+		return MedianCutExtractor{}, nil
+	case "kmeans":
+		return KMeansExtractor{}, nil
+	}
+	return nil, fmt.Errorf("unknown palette extractor %q", name)
+}
+
+// histPixel is a distinct color of an image, with its number of pixels.
+type histPixel struct {
+	c color.NRGBA
+	n int
+}
+
+// histogram returns the distinct colors of the image.
+// Fully transparent pixels are skipped.
+func histogram(m image.Image) []histPixel {
+	idx := map[color.NRGBA]int{}
+	var pixels []histPixel
+	b := m.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(m.At(x, y)).(color.NRGBA)
+			if c.A == 0 {
+				continue
+			}
+			c.A = 255
+			if j, ok := idx[c]; ok {
+				pixels[j].n++
+				continue
+			}
+			idx[c] = len(pixels)
+			pixels = append(pixels, histPixel{c, 1})
+		}
+	}
+	return pixels
+}
+
+// medianCut splits the colors in at most n boxes.
+func medianCut(pixels []histPixel, n int) [][]histPixel {
+	if len(pixels) == 0 || n <= 0 {
+		return nil
+	}
+	// the boxes are sorted in place
+	boxes := [][]histPixel{append([]histPixel{}, pixels...)}
+	for len(boxes) < n {
+		// the box with the most pixels that can be split
+		best, bestCount := -1, 0
+		for j, b := range boxes {
+			if len(b) < 2 {
+				continue
+			}
+			count := 0
+			for _, p := range b {
+				count += p.n
+			}
+			if count > bestCount {
+				best, bestCount = j, count
+			}
+		}
+		if best < 0 {
+			break
+		}
+		b1, b2 := splitBox(boxes[best], bestCount)
+		boxes[best] = b1
+		boxes = append(boxes, b2)
+	}
+	return boxes
+}
+
+// component returns the j-th component (R, G or B) of the color.
+func component(c color.NRGBA, j int) uint8 {
+	switch j {
+	case 0:
+		return c.R
+	case 1:
+		return c.G
+	}
+	return c.B
+}
+
+// splitBox splits the box, of count pixels, at the median
+// of its widest component. Both the returned boxes are not empty.
+func splitBox(box []histPixel, count int) ([]histPixel, []histPixel) {
+	widest, widestRange := 0, -1
+	for j := 0; j < 3; j++ {
+		min, max := uint8(255), uint8(0)
+		for _, p := range box {
+			v := component(p.c, j)
+			if v < min {
+				min = v
+			}
+			if v > max {
+				max = v
+			}
+		}
+		if r := int(max) - int(min); r > widestRange {
+			widest, widestRange = j, r
+		}
+	}
+	sort.SliceStable(box, func(a, b int) bool {
+		return component(box[a].c, widest) < component(box[b].c, widest)
+	})
+
+	half, k := 0, 0
+	for k < len(box)-1 && half+box[k].n <= count/2 {
+		half += box[k].n
+		k++
+	}
+	if k == 0 {
+		k = 1
+	}
+	return box[:k:k], box[k:]
+}
+
+// cluster accumulates colors to compute their mean.
+type cluster struct {
+	r, g, b float64
+	n       int
+}
+
+func (c *cluster) add(p histPixel) {
+	c.r += float64(p.c.R) * float64(p.n)
+	c.g += float64(p.c.G) * float64(p.n)
+	c.b += float64(p.c.B) * float64(p.n)
+	c.n += p.n
+}
+
+func (c *cluster) color() color.NRGBA {
+	n := float64(c.n)
+	return color.NRGBA{clamp8(c.r / n), clamp8(c.g / n), clamp8(c.b / n), 255}
+}
+
+// clusterPalette returns the colors of the non empty clusters,
+// sorted by population.
+func clusterPalette(clusters []cluster) color.Palette {
+	sort.SliceStable(clusters, func(i, j int) bool { return clusters[i].n > clusters[j].n })
+	var pal color.Palette
+	for _, c := range clusters {
+		if c.n > 0 {
+			pal = append(pal, c.color())
+		}
+	}
+	return pal
+}
@@ -0,0 +1,386 @@
+package image
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+// Grid is the grid of the cells of a pixel art image upscaled
+// by an unknown factor, like a screenshot of a sprite: each cell
+// is a pixel of the original image.
+type Grid struct {
+	// X0 and Y0 are the coordinates of the top left corner
+	// of the first cell, relative to the image bounds.
+	// They are negative if the first cell is cut.
+	X0, Y0 float64
+	// CellW and CellH are the dimensions of a cell in pixels:
+	// they are fractional if the image was scaled by a fractional factor.
+	CellW, CellH float64
+	// Cols and Rows are the number of cells of the grid.
+	Cols, Rows int
+}
+
+func (g Grid) String() string {
+	return fmt.Sprintf("%dx%d cells of %gx%g pixels at (%g,%g)", g.Cols, g.Rows, g.CellW, g.CellH, g.X0, g.Y0)
+}
+
+// Footprint returns the area of the image of bounds b covered by the cell (x, y).
+func (g Grid) Footprint(b image.Rectangle, x, y int) Footprint {
+	x0 := float64(b.Min.X) + g.X0 + float64(x)*g.CellW
+	y0 := float64(b.Min.Y) + g.Y0 + float64(y)*g.CellH
+	return Footprint{x0, y0, x0 + g.CellW, y0 + g.CellH}
+}
+
+// gridEdgeThreshold is the minimum difference, as the sum of the
+// differences of the components, between two adjacent pixels
+// for them to be in different cells. It ignores the noise of the
+// jpeg compression.
+const gridEdgeThreshold = 48
+
+// gridFit is the minimum fraction of the edges that must lie
+// on the lines of a grid for the grid to be accepted.
+const gridFit = 0.9
+
+// DetectGrid detects the grid of the cells of the image m,
+// a pixel art image upscaled by an unknown factor.
+// A grid of cells of one pixel is returned if m is not upscaled.
+func DetectGrid(m image.Image) (Grid, error) {
+	b := m.Bounds()
+	colEdges, rowEdges := edgeProfiles(m)
+	x0, cw, err := detectLines(colEdges, b.Dx())
+	if err != nil {
+		return Grid{}, fmt.Errorf("DetectGrid: columns: %w", err)
+	}
+	y0, ch, err := detectLines(rowEdges, b.Dy())
+	if err != nil {
+		return Grid{}, fmt.Errorf("DetectGrid: rows: %w", err)
+	}
+	return Grid{
+		X0:    x0,
+		Y0:    y0,
+		CellW: cw,
+		CellH: ch,
+		Cols:  int(math.Floor((float64(b.Dx())-x0)/cw + 0.5)),
+		Rows:  int(math.Floor((float64(b.Dy())-y0)/ch + 0.5)),
+	}, nil
+}
+
+// edgeProfiles returns, for each x (y), the number of rows (columns)
+// where the pixel at x (y) differs from the previous one.
+func edgeProfiles(m image.Image) ([]float64, []float64) {
+	b := m.Bounds()
+	cols := make([]float64, b.Dx())
+	rows := make([]float64, b.Dy())
+	differ := func(c1, c2 color.NRGBA) bool {
+		d := absDiff(c1.R, c2.R) + absDiff(c1.G, c2.G) + absDiff(c1.B, c2.B) + absDiff(c1.A, c2.A)
+		return d > gridEdgeThreshold
+	}
+	prevRow := make([]color.NRGBA, b.Dx())
+	for y := 0; y < b.Dy(); y++ {
+		var prev color.NRGBA
+		for x := 0; x < b.Dx(); x++ {
+			c := color.NRGBAModel.Convert(m.At(b.Min.X+x, b.Min.Y+y)).(color.NRGBA)
+			if c.A == 0 {
+				c = color.NRGBA{}
+			}
+			if x > 0 && differ(prev, c) {
+				cols[x]++
+			}
+			if y > 0 && differ(prevRow[x], c) {
+				rows[y]++
+			}
+			prev, prevRow[x] = c, c
+		}
+	}
+	return cols, rows
+}
+
+func absDiff(a, b uint8) int {
+	if a > b {
+		return int(a - b)
+	}
+	return int(b - a)
+}
+
+// detectLines returns the offset and the spacing of the largest
+// grid of lines, on a side of n pixels, matching the edges:
+// edges[p] is the weight of the edge between the pixels p-1 and p.
+func detectLines(edges []float64, n int) (float64, float64, error) {
+	var pos, weights []float64
+	var total float64
+	for p, w := range edges {
+		if w > 0 {
+			pos = append(pos, float64(p))
+			weights = append(weights, w)
+			total += w
+		}
+	}
+	if len(pos) < 2 {
+		return 0, 0, errors.New("not enough edges")
+	}
+
+	// the spacings are tried from the largest, in steps small enough
+	// not to move the last line of the grid more than 1/4 pixel;
+	// a scale factor less than 2 can not be told from the details
+	// of an image that is not upscaled
+	step := 1 - 0.25/float64(n)
+	for s := float64(n) / 2; s >= 2; {
+		// the spacings down to lo move the lines at most drift pixels:
+		// they are tried only if the edges can fit a grid of spacing s
+		// with the tolerance increased by drift
+		lo := s - s*s/(16*float64(n))
+		drift := float64(n) / lo * (s - lo)
+		if !mayFitLines(pos, weights, s, lineTolerance(s)+drift, gridFit*total) {
+			for s > lo {
+				s *= step
+			}
+			continue
+		}
+		for ; s > lo && s >= 2; s *= step {
+			o := linesOffset(pos, weights, s)
+			var fit float64
+			for j, p := range pos {
+				if _, d := nearestLine(p, o, s); d <= lineTolerance(s) {
+					fit += weights[j]
+				}
+			}
+			if fit >= gridFit*total {
+				o, s = refineLines(pos, weights, o, s)
+				return o, s, nil
+			}
+		}
+	}
+	// not upscaled
+	return 0, 1, nil
+}
+
+// lineTolerance returns the maximum distance of an edge from its line,
+// in a grid of spacing s. It is less than s/4, so that the edges
+// halfway between the lines, as in an image that is not upscaled,
+// do not fit the grids of small spacings.
+func lineTolerance(s float64) float64 {
+	return math.Min(0.75, s/4-0.01)
+}
+
+// mayFitLines reports whether at least the weight min of the edges is
+// within tol from the lines of a grid of spacing s, for some offset.
+// It is a quick test: the edges are grouped by their distance from
+// the lines in bins, and it can report true also if no grid fits.
+func mayFitLines(pos, weights []float64, s, tol, min float64) bool {
+	const bins = 128
+	var hist [bins]float64
+	for j, p := range pos {
+		phase := p/s - math.Floor(p/s)
+		hist[int(phase*bins)%bins] += weights[j]
+	}
+	// the bins overlapping an interval of 2*tol pixels
+	width := int(math.Ceil(2*tol/s*bins)) + 1
+	if width >= bins {
+		return true
+	}
+	var sum float64
+	for b := 0; b < width; b++ {
+		sum += hist[b]
+	}
+	for b := 0; b < bins; b++ {
+		if sum >= min {
+			return true
+		}
+		sum += hist[(b+width)%bins] - hist[b]
+	}
+	return false
+}
+
+// nearestLine returns the index of the line of the grid of offset o
+// and spacing s nearest to p, and its distance from p.
+func nearestLine(p, o, s float64) (float64, float64) {
+	k := math.Floor((p-o)/s + 0.5)
+	return k, math.Abs(p - o - k*s)
+}
+
+// linesOffset returns the offset in [0, s) of the grid of lines
+// of spacing s best matching the edges, as their circular mean.
+func linesOffset(pos, weights []float64, s float64) float64 {
+	var sin, cos float64
+	for j, p := range pos {
+		a := 2 * math.Pi * p / s
+		sin += weights[j] * math.Sin(a)
+		cos += weights[j] * math.Cos(a)
+	}
+	o := math.Atan2(sin, cos) / (2 * math.Pi) * s
+	if o < 0 {
+		o += s
+	}
+	return o
+}
+
+// refineLines refines the offset and the spacing of the grid of lines
+// with the least squares fit of the edges to their nearest lines.
+// The cells of the returned grid start from the first line, or from
+// the line before if at least half of the first cell is in the image.
+func refineLines(pos, weights []float64, o, s float64) (float64, float64) {
+	var sw, sk, sp, skk, skp float64
+	for j, p := range pos {
+		k, d := nearestLine(p, o, s)
+		if d > lineTolerance(s) {
+			continue
+		}
+		w := weights[j]
+		sw += w
+		sk += w * k
+		sp += w * p
+		skk += w * k * k
+		skp += w * k * p
+	}
+	if det := sw*skk - sk*sk; det != 0 {
+		s = (sw*skp - sk*sp) / det
+		o = (sp - s*sk) / sw
+	}
+	// the integer values are exact
+	snap := func(v float64) float64 {
+		if r := math.Floor(v + 0.5); math.Abs(v-r) < 0.05 {
+			return r
+		}
+		return v
+	}
+	s = snap(s)
+	o = snap(math.Mod(o, s))
+	if o < 0 {
+		o += s
+	}
+	if o >= s/2 {
+		o -= s
+	}
+	return o, s
+}
+
+// Pixelate returns the image of the cells of the grid.
+// The color of each cell is computed by the sampler.
+func (g Grid) Pixelate(m image.Image, sampler Sampler) (image.Image, error) {
+	if g.Cols <= 0 || g.Rows <= 0 {
+		return nil, errors.New("Grid.Pixelate: empty grid")
+	}
+	b := m.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, g.Cols, g.Rows))
+	for y := 0; y < g.Rows; y++ {
+		for x := 0; x < g.Cols; x++ {
+			c, err := sampler(m, g.Footprint(b, x, y))
+			if err != nil {
+				return nil, fmt.Errorf("Grid.Pixelate: cell (%d,%d): %w", x, y, err)
+			}
+			dst.Set(x, y, c)
+		}
+	}
+	return dst, nil
+}
+
+// sampleCellCenter returns the color covering the largest area of the
+// central part of the cell: the pixels at the border of the cell can be
+// blended with the near cells by the scaling.
+func sampleCellCenter(m image.Image, f Footprint) (color.Color, error) {
+	dx, dy := (f.X1-f.X0)/4, (f.Y1-f.Y0)/4
+	return SampleMode(m, Footprint{f.X0 + dx, f.Y0 + dy, f.X1 - dx, f.Y1 - dy})
+}
+
+// Unscale detects the grid of the cells of the image m, a pixel art
+// image upscaled by an unknown factor, and returns the paletted image
+// of the cells, with the exact colors of the image. It fails if the
+// image has more than 256 colors, like a photo or a jpeg image.
+func Unscale(m image.Image) (*image.Paletted, Grid, error) {
+	g, err := DetectGrid(m)
+	if err != nil {
+		return nil, g, err
+	}
+	mm, err := g.Pixelate(m, sampleCellCenter)
+	if err != nil {
+		return nil, g, err
+	}
+
+	imgpal, ok := exactPaletted(mm, nil, 256)
+	if !ok {
+		return nil, g, fmt.Errorf("Unscale: more than 256 colors in the cells of the grid %v", g)
+	}
+	return imgpal, g, nil
+}
+
+// exactPaletted returns the paletted image of m with the exact
+// colors of m, if m has at most max colors. The palette starts
+// with the colors of seed, if any, in the same order.
+func exactPaletted(m image.Image, seed color.Palette, max int) (*image.Paletted, bool) {
+	var pal color.Palette
+	idx := map[color.NRGBA]int{}
+	normalize := func(c color.Color) color.NRGBA {
+		n := color.NRGBAModel.Convert(c).(color.NRGBA)
+		if n.A == 0 {
+			n = color.NRGBA{}
+		}
+		return n
+	}
+	add := func(c color.NRGBA) bool {
+		if _, ok := idx[c]; ok {
+			return true
+		}
+		if len(pal) == max {
+			return false
+		}
+		idx[c] = len(pal)
+		pal = append(pal, c)
+		return true
+	}
+	for _, c := range seed {
+		if !add(normalize(c)) {
+			return nil, false
+		}
+	}
+	b := m.Bounds()
+	colorAt := func(x, y int) color.NRGBA {
+		return normalize(m.At(x, y))
+	}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if !add(colorAt(x, y)) {
+				return nil, false
+			}
+		}
+	}
+	imgpal := image.NewPaletted(b, pal)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			imgpal.SetColorIndex(x, y, uint8(idx[colorAt(x, y)]))
+		}
+	}
+	return imgpal, true
+}
+
+// countColors returns the number of colors of m, counting up to max.
+// All the fully transparent colors count as one.
+func countColors(m image.Image, max int) int {
+	seen := map[color.NRGBA]bool{}
+	b := m.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(m.At(x, y)).(color.NRGBA)
+			if c.A == 0 {
+				c = color.NRGBA{}
+			}
+			seen[c] = true
+			if len(seen) >= max {
+				return max
+			}
+		}
+	}
+	return len(seen)
+}
+
+// LoadUnscaled loads the pixel art image at path and
+// returns the paletted image of its cells. See Unscale.
+func LoadUnscaled(path string) (*image.Paletted, Grid, error) {
+	m, _, err := Load(path)
+	if err != nil {
+		return nil, Grid{}, err
+	}
+	return Unscale(m)
+}
@@ -6,8 +6,6 @@ import (
 	"image"
 	"image/color"
 	"image/gif"
-	// to read jpeg images
-	_ "image/jpeg"
 	"image/png"
 	"log"
 	"os"
@@ -17,7 +15,9 @@ import (
 	"golang.org/x/image/draw"
 )
 
-// SaveAsGif saves the image in the gif format.
+// SaveAsGif saves the image in the gif format. A *image.Paletted
+// keeps its palette, if it has at most 256 colors; the other images
+// are quantized to 256 colors.
 func SaveAsGif(m image.Image, path string) error {
 	// outputFile is a File type which satisfies Writer interface
 	outputFile, err := os.Create(path)
@@ -27,7 +27,7 @@ func SaveAsGif(m image.Image, path string) error {
 	defer outputFile.Close()
 
 	opt := gif.Options{
-		NumColors: 8,
+		NumColors: 256,
 	}
 
 	err = gif.Encode(outputFile, m, &opt)
@@ -55,114 +55,29 @@ func SaveAsPng(m image.Image, path string) error {
 	return nil
 }
 
-// Zoom enlarge the image by a factor of (mx,my).
+// Zoom enlarges the image by a factor of (mx,my). A *image.Paletted
+// stays paletted. See ZoomWith for the gridlines and the border.
 func Zoom(m image.Image, mx, my int) (image.Image, error) {
-
-	bounds := m.Bounds()
-	Dx := bounds.Dx()
-	Dy := bounds.Dy()
-
-	g := image.NewNRGBA(image.Rect(0, 0, Dx*mx, Dy*my))
-
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-
-			c := m.At(x, y)
-			xx := mx * (x - bounds.Min.X)
-			yy := my * (y - bounds.Min.Y)
-			for iy := 0; iy < my; iy++ {
-				for ix := 0; ix < mx; ix++ {
-					g.Set(xx+ix, yy+iy, c)
-				}
-			}
-
-		}
-	}
-	return g, nil
+	zoomed, _, err := ZoomWith(m, ZoomOptions{CellW: float64(mx), CellH: float64(my)})
+	return zoomed, err
 }
 
-// AverageImageColor is  ...
-//  https://jimsaunders.net/2015/05/22/manipulating-colors-in-go.html
+// AverageImageColor returns the mean color of the image,
+// computed in the sRGB space. See AverageColor.
 func AverageImageColor(i image.Image) color.Color {
-	var r, g, b uint32
-
-	bounds := i.Bounds()
-
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			pr, pg, pb, _ := i.At(x, y).RGBA()
-			r += pr
-			g += pg
-			b += pb
-		}
-	}
-
-	d := uint32(bounds.Dy() * bounds.Dx() * 0x101)
-
-	r /= d
-	g /= d
-	b /= d
-
-	return color.NRGBA{uint8(r), uint8(g), uint8(b), 255}
-}
-
-type colorSamplerFunc func(m image.Image, x, y int) color.Color
-
-func colorAt(m image.Image, x, y int) color.Color {
-	return m.At(x, y)
+	return AverageColor(i, false)
 }
 
-func colorAverageFactory(w, h int) colorSamplerFunc {
-	if w == 1 && h == 1 {
-		return colorAt
-	}
-
-	fn := func(m image.Image, x, y int) color.Color {
-		var si image.Image
-
-		dx, dy := w/2, h/2
-		r := image.Rect(x-dx, y-dy, x+dx, y+dy)
-
-		switch i := m.(type) {
-		case *image.Alpha:
-			si = i.SubImage(r)
-		case *image.Alpha16:
-			si = i.SubImage(r)
-		case *image.RGBA:
-			si = i.SubImage(r)
-		case *image.NRGBA:
-			si = i.SubImage(r)
-		case *image.YCbCr:
-			si = i.SubImage(r)
-		default:
-			log.Fatal("Invalid image type")
-		}
-		return AverageImageColor(si)
-
-	}
-
-	return fn
-}
-
-func loadImage(path string) (image.Image, error) {
-	reader, err := os.Open(path)
-	if err != nil {
-		return nil, err
-	}
-	defer reader.Close()
-	m, imageType, err := image.Decode(reader)
-	if err != nil {
-		return nil, err
-	}
-	fmt.Printf("image-type = %s\n", imageType)
-	return m, nil
-}
-
-// Pixelate is ...
-func Pixelate(m image.Image, sampler colorSamplerFunc, pixelx, pixely int) (image.Image, error) {
+// Pixelate reduces the image to pixelx columns and pixely rows.
+// The color of each cell is computed by the sampler
+// from the area of the image covered by the cell.
+func Pixelate(m image.Image, sampler Sampler, pixelx, pixely int) (image.Image, error) {
 	bounds := m.Bounds()
 	Dx := bounds.Dx()
 	Dy := bounds.Dy()
+	if pixelx <= 0 || pixely <= 0 {
+		return nil, errors.New("Pixelate: invalid destination size")
+	}
 	if Dx < pixelx {
 		return nil, errors.New("Pixelate: destination width bigger that source image width")
 	}
@@ -171,38 +86,29 @@ func Pixelate(m image.Image, sampler colorSamplerFunc, pixelx, pixely int) (imag
 	}
 
 	g := image.NewNRGBA(image.Rect(0, 0, pixelx, pixely))
-	fmt.Printf("rect.DX = %d\nrect.DY = %d\n", g.Bounds().Dx(), g.Bounds().Dy())
-
-	sx := float32(Dx) / float32(pixelx)
-	sy := float32(Dy) / float32(pixely)
-
-	fmt.Printf("Dx=%d, dx=%d, sx=%f\n", Dx, pixelx, sx)
-	fmt.Printf("Dy=%d, dy=%d, sy=%f\n", Dy, pixely, sy)
 
-	ry := sy / 2
 	for y := 0; y < pixely; y++ {
-
-		rx := sx / 2
 		for x := 0; x < pixelx; x++ {
-
-			c := sampler(m, int(rx+0.5), int(ry+0.5))
+			c, err := sampler(m, cellFootprint(bounds, pixelx, pixely, x, y))
+			if err != nil {
+				return nil, fmt.Errorf("Pixelate: cell (%d,%d): %w", x, y, err)
+			}
 			g.Set(x, y, c)
-			//	fmt.Printf("[%d,%d] = %v\n", x, y, c)
-
-			rx += sx
 		}
-		ry += sy
 	}
 
 	return g, nil
-
 }
-func palettedImage(m image.Image, pal color.Palette) *image.Paletted {
-	bounds := m.Bounds()
-	palImg := image.NewPaletted(bounds, pal)
-	draw.Draw(palImg, palImg.Rect, m, bounds.Min, draw.Over)
 
-	return palImg
+// cellFootprint returns the footprint, in the image of bounds b,
+// of the cell (x, y) of the pixelated image of pixelx columns
+// and pixely rows.
+func cellFootprint(b image.Rectangle, pixelx, pixely, x, y int) Footprint {
+	sx := float64(b.Dx()) / float64(pixelx)
+	sy := float64(b.Dy()) / float64(pixely)
+	x0 := float64(b.Min.X) + float64(x)*sx
+	y0 := float64(b.Min.Y) + float64(y)*sy
+	return Footprint{x0, y0, x0 + sx, y0 + sy}
 }
 
 func getPal(i image.Image, maximumColorCount int) color.Palette {
@@ -237,39 +143,158 @@ func (p hueSwatchSorter) Len() int           { return len(p) }
 func (p hueSwatchSorter) Less(i, j int) bool { return p[i].HSL().H < p[j].HSL().H }
 func (p hueSwatchSorter) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
 
-func Pokemon() *image.Paletted {
-
-	input := "img/pokemon.jpg"
+// PalettedOptions are the options used to convert an image
+// to a paletted image.
+type PalettedOptions struct {
+	// Width and Height are the dimensions of the pixelated image.
+	// If Height is 0, it is computed from Width and Aspect,
+	// keeping the proportions of the source image.
+	Width, Height int
+	// Aspect is the ratio between the width and the height of a cell.
+	Aspect Aspect
+	// DetectGrid is used for pixel art images upscaled by an unknown
+	// factor: the cells of the image are detected and used instead
+	// of Width, Height and Sampler. If the cells have at most NumColors
+	// colors, and no catalog is given, their exact colors are kept.
+	DetectGrid bool
+	// NumColors is the maximum number of colors of the palette.
+	NumColors int
+	// Sampler computes the color of each cell of the pixelated image.
+	// If nil, SampleBox is used.
+	Sampler Sampler
+	// Outlines, if not nil, restores the outlines of the image
+	// lost by the sampling. See ThickenOutlines.
+	Outlines *OutlineOptions
+	// Perceptual selects the colors of the palette maximizing
+	// their perceptual (CIEDE2000) distance, instead of taking
+	// the most used ones.
+	Perceptual bool
+	// Catalog, if not nil, restricts the colors of the palette
+	// to the nearest colors of the catalog.
+	Catalog *Catalog
+	// Dither is the strategy used to map the colors
+	// of the image to the palette.
+	Dither Dither
+	// Extractor extracts the palette from the pixelated image.
+	// If nil, VibrantExtractor is used.
+	Extractor PaletteExtractor
+}
 
-	m, err := loadImage(input)
-	if err != nil {
-		log.Fatal(err)
+// ToPaletted pixelates the image and reduces its colors
+// to the palette extracted from the pixelated image.
+// NumColors can be at most 256: see ToImage for more colors.
+func ToPaletted(m image.Image, opt *PalettedOptions) (*image.Paletted, error) {
+	if opt.NumColors > 256 {
+		return nil, fmt.Errorf("ToPaletted: %d colors, more than 256", opt.NumColors)
 	}
-	//fn := colorAt
-	fn := colorAverageFactory(3, 3)
-	mm, err := Pixelate(m, fn, 41, 38)
+	mm, err := ToImage(m, opt)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+	return mm.(*image.Paletted), nil
+}
+
+// ToImage is like ToPaletted, but NumColors can be more than 256.
+// The returned image is a *image.Paletted if it has at most 256 colors,
+// else a *image.NRGBA.
+func ToImage(m image.Image, opt *PalettedOptions) (image.Image, error) {
+	fn := opt.Sampler
+	if fn == nil {
+		fn = SampleBox
 	}
-	/*
-		mm2, err := Zoom(mm, 16, 16)
+	var mm image.Image
+	if opt.DetectGrid {
+		g, err := DetectGrid(m)
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
-
-			err = SaveAsPng(mm2, "pokemon-2.png")
-			if err != nil {
-				log.Fatal(err)
+		if mm, err = g.Pixelate(m, sampleCellCenter); err != nil {
+			return nil, err
+		}
+		if opt.Catalog == nil {
+			if opt.NumColors <= 256 {
+				if imgpal, ok := exactPaletted(mm, nil, opt.NumColors); ok {
+					return imgpal, nil
+				}
+			} else if countColors(mm, opt.NumColors+1) <= opt.NumColors {
+				return mm, nil
 			}
-	*/
-	pal := getPal(mm, 8)
-	imgpal := palettedImage(mm, pal)
-	/*
-		//	saveCoding("coding.txt", imgpal)
-		err = SaveAsPng(imgpal, "pokemon-paletted.png")
-		if err != nil {
-			log.Fatal(err)
 		}
-	*/
+	} else {
+		width, height := opt.Width, opt.Height
+		if height <= 0 {
+			width, height = GridSize(m.Bounds(), width, opt.Aspect)
+		}
+		var err error
+		if mm, err = Pixelate(m, fn, width, height); err != nil {
+			return nil, err
+		}
+		if opt.Outlines != nil {
+			if err = ThickenOutlines(m, mm.(draw.Image), *opt.Outlines); err != nil {
+				return nil, err
+			}
+		}
+	}
+	ext := opt.Extractor
+	if ext == nil {
+		ext = VibrantExtractor{}
+	}
+	num := opt.NumColors
+	transparent := hasTransparent(mm)
+	if transparent && num == 256 {
+		// the transparent color is the 256th color of the paletted image
+		num = 255
+	}
+	count := num
+	if opt.Catalog != nil || opt.Perceptual {
+		// more candidates, to choose from
+		count *= 4
+	}
+	pal, err := ext.Extract(mm, count)
+	if err != nil {
+		return nil, err
+	}
+	if opt.Catalog != nil {
+		// some candidates can snap to the same color
+		pal = opt.Catalog.Snap(pal)
+	}
+	switch {
+	case opt.Perceptual:
+		pal = PerceptualPalette(pal, num)
+	case len(pal) > num:
+		pal = pal[:num]
+	}
+	if len(pal) == 0 {
+		return nil, errors.New("empty palette")
+	}
+	if transparent {
+		// the transparent cells are mapped to the transparent color
+		pal = append(pal, color.Transparent)
+	}
+	return QuantizeImage(mm, pal, opt.Dither), nil
+}
+
+// LoadPaletted loads the image at path and converts it
+// to a paletted image.
+func LoadPaletted(path string, opt *PalettedOptions) (*image.Paletted, error) {
+	m, _, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+	return ToPaletted(m, opt)
+}
+
+// Pokemon returns the paletted image of the pokemon example.
+func Pokemon() *image.Paletted {
+	opt := PalettedOptions{
+		Width:     41,
+		Height:    38,
+		NumColors: 8,
+		Sampler:   SampleBox,
+	}
+	imgpal, err := LoadPaletted("img/pokemon.jpg", &opt)
+	if err != nil {
+		log.Fatal(err)
+	}
 	return imgpal
 }
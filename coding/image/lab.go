@@ -0,0 +1,214 @@
+package image
+
+import (
+	"image/color"
+	"math"
+)
+
+// Lab represents a color in the CIE L*a*b* color space,
+// with the D65 reference white.
+type Lab struct {
+	L, A, B float64
+}
+
+// D65 reference white
+const (
+	whiteX = 0.95047
+	whiteY = 1.0
+	whiteZ = 1.08883
+)
+
+// linearize converts an 8 bit sRGB component to linear light.
+func linearize(v uint8) float64 {
+	c := float64(v) / 255
+	if c <= 0.04045 {
+		return c / 12.92
+	}
+	return math.Pow((c+0.055)/1.055, 2.4)
+}
+
+// delinearize converts a linear light value to an 8 bit sRGB component.
+func delinearize(c float64) uint8 {
+	if c <= 0 {
+		return 0
+	}
+	if c >= 1 {
+		return 255
+	}
+	if c <= 0.0031308 {
+		c *= 12.92
+	} else {
+		c = 1.055*math.Pow(c, 1/2.4) - 0.055
+	}
+	return uint8(c*255 + 0.5)
+}
+
+// linearRGB returns the linear light components of the color.
+// The alpha channel is ignored.
+func linearRGB(c color.Color) (float64, float64, float64) {
+	r, g, b, _ := rgba(c)
+	return linearize(r), linearize(g), linearize(b)
+}
+
+// ToLab converts the color to the CIE L*a*b* color space.
+// The alpha channel is ignored.
+func ToLab(c color.Color) Lab {
+	r, g, b := linearRGB(c)
+
+	x := 0.4124564*r + 0.3575761*g + 0.1804375*b
+	y := 0.2126729*r + 0.7151522*g + 0.0721750*b
+	z := 0.0193339*r + 0.1191920*g + 0.9503041*b
+
+	f := func(t float64) float64 {
+		const delta = 6.0 / 29
+		if t > delta*delta*delta {
+			return math.Cbrt(t)
+		}
+		return t/(3*delta*delta) + 4.0/29
+	}
+	fx, fy, fz := f(x/whiteX), f(y/whiteY), f(z/whiteZ)
+
+	return Lab{
+		L: 116*fy - 16,
+		A: 500 * (fx - fy),
+		B: 200 * (fy - fz),
+	}
+}
+
+func deg2rad(d float64) float64 { return d * math.Pi / 180 }
+func rad2deg(r float64) float64 { return r * 180 / math.Pi }
+
+// DeltaE2000 returns the CIEDE2000 color difference between two colors.
+// A difference below 1 is not perceptible by human eyes;
+// a difference below 10 is perceptible only at a close look.
+// See http://www2.ece.rochester.edu/~gsharma/ciede2000/
+func DeltaE2000(c1, c2 Lab) float64 {
+	const pow25_7 = 6103515625 // 25^7
+
+	cab1 := math.Hypot(c1.A, c1.B)
+	cab2 := math.Hypot(c2.A, c2.B)
+	cab := (cab1 + cab2) / 2
+	cab7 := math.Pow(cab, 7)
+	g := 0.5 * (1 - math.Sqrt(cab7/(cab7+pow25_7)))
+
+	a1 := (1 + g) * c1.A
+	a2 := (1 + g) * c2.A
+	cp1 := math.Hypot(a1, c1.B)
+	cp2 := math.Hypot(a2, c2.B)
+
+	hue := func(a, b float64) float64 {
+		if a == 0 && b == 0 {
+			return 0
+		}
+		h := rad2deg(math.Atan2(b, a))
+		if h < 0 {
+			h += 360
+		}
+		return h
+	}
+	hp1 := hue(a1, c1.B)
+	hp2 := hue(a2, c2.B)
+
+	dL := c2.L - c1.L
+	dC := cp2 - cp1
+
+	var dh float64
+	if cp1*cp2 != 0 {
+		dh = hp2 - hp1
+		if dh > 180 {
+			dh -= 360
+		} else if dh < -180 {
+			dh += 360
+		}
+	}
+	dH := 2 * math.Sqrt(cp1*cp2) * math.Sin(deg2rad(dh/2))
+
+	lp := (c1.L + c2.L) / 2
+	cp := (cp1 + cp2) / 2
+
+	hp := hp1 + hp2
+	if cp1*cp2 != 0 {
+		if math.Abs(hp1-hp2) > 180 {
+			if hp < 360 {
+				hp += 360
+			} else {
+				hp -= 360
+			}
+		}
+		hp /= 2
+	}
+
+	t := 1 - 0.17*math.Cos(deg2rad(hp-30)) +
+		0.24*math.Cos(deg2rad(2*hp)) +
+		0.32*math.Cos(deg2rad(3*hp+6)) -
+		0.20*math.Cos(deg2rad(4*hp-63))
+
+	dTheta := 30 * math.Exp(-((hp-275)/25)*((hp-275)/25))
+	cp7 := math.Pow(cp, 7)
+	rc := 2 * math.Sqrt(cp7/(cp7+pow25_7))
+	lp50 := (lp - 50) * (lp - 50)
+	sl := 1 + 0.015*lp50/math.Sqrt(20+lp50)
+	sc := 1 + 0.045*cp
+	sh := 1 + 0.015*cp*t
+	rt := -math.Sin(deg2rad(2*dTheta)) * rc
+
+	kL := dL / sl
+	kC := dC / sc
+	kH := dH / sh
+
+	return math.Sqrt(kL*kL + kC*kC + kH*kH + rt*kC*kH)
+}
+
+// ColorDistance returns the CIEDE2000 color difference between two colors.
+func ColorDistance(c1, c2 color.Color) float64 {
+	return DeltaE2000(ToLab(c1), ToLab(c2))
+}
+
+// PerceptualPalette selects from the candidate colors at most n colors,
+// maximizing the minimum perceptual distance between them.
+// The first candidate is always selected: if the candidates are sorted
+// by population, the most used color is kept.
+func PerceptualPalette(candidates color.Palette, n int) color.Palette {
+	if n <= 0 || len(candidates) == 0 {
+		return color.Palette{}
+	}
+	if n >= len(candidates) {
+		return append(color.Palette{}, candidates...)
+	}
+
+	labs := make([]Lab, len(candidates))
+	for j, c := range candidates {
+		labs[j] = ToLab(c)
+	}
+
+	// minDist[j] is the distance of candidate j from the selected colors
+	minDist := make([]float64, len(candidates))
+	for j := range minDist {
+		minDist[j] = math.Inf(1)
+	}
+
+	pal := make(color.Palette, 0, n)
+	next := 0
+	for len(pal) < n {
+		pal = append(pal, candidates[next])
+		minDist[next] = -1
+
+		best := -1
+		for j := range candidates {
+			if minDist[j] < 0 {
+				continue
+			}
+			if d := DeltaE2000(labs[next], labs[j]); d < minDist[j] {
+				minDist[j] = d
+			}
+			if best < 0 || minDist[j] > minDist[best] {
+				best = j
+			}
+		}
+		if best < 0 {
+			break
+		}
+		next = best
+	}
+	return pal
+}
@@ -0,0 +1,157 @@
+package image
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"io"
+	"os"
+
+	// the decoders of the supported formats
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// Decode decodes an image in any of the supported formats: gif, jpeg,
+// png, bmp, tiff and webp. It returns the image and the name of its
+// format. A jpeg image is rotated and flipped as required by its
+// EXIF orientation, so that it is returned as it should be displayed.
+func Decode(r io.Reader) (image.Image, string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, "", err
+	}
+	m, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+	if format == "jpeg" {
+		m = orient(m, jpegOrientation(data))
+	}
+	return m, format, nil
+}
+
+// Load loads the image at path. See Decode.
+func Load(path string) (image.Image, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+	return Decode(f)
+}
+
+// jpegOrientation returns the EXIF orientation of the jpeg image,
+// from 1 to 8, or 1 if the image has no valid orientation tag.
+func jpegOrientation(data []byte) int {
+	if len(data) < 2 || data[0] != 0xff || data[1] != 0xd8 {
+		return 1
+	}
+	// the segments before the image data
+	for p := 2; p+4 <= len(data); {
+		if data[p] != 0xff {
+			return 1
+		}
+		marker := data[p+1]
+		if marker == 0xda || marker == 0xd9 {
+			// start of scan or end of image
+			return 1
+		}
+		n := int(binary.BigEndian.Uint16(data[p+2:]))
+		if n < 2 || p+2+n > len(data) {
+			return 1
+		}
+		seg := data[p+4 : p+2+n]
+		if marker == 0xe1 && bytes.HasPrefix(seg, []byte("Exif\x00\x00")) {
+			return exifOrientation(seg[6:])
+		}
+		p += 2 + n
+	}
+	return 1
+}
+
+// exifOrientation returns the orientation tag of the first IFD
+// of the TIFF structure of the EXIF data, or 1 if not found.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	if order.Uint16(tiff[2:]) != 42 {
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	n := int(order.Uint16(tiff[ifd:]))
+	for j := 0; j < n; j++ {
+		e := ifd + 2 + 12*j
+		if e+12 > len(tiff) {
+			return 1
+		}
+		// tag 0x0112 of type SHORT
+		if order.Uint16(tiff[e:]) == 0x0112 && order.Uint16(tiff[e+2:]) == 3 {
+			if o := int(order.Uint16(tiff[e+8:])); o >= 1 && o <= 8 {
+				return o
+			}
+			return 1
+		}
+	}
+	return 1
+}
+
+// orient returns the image m transformed as required by the EXIF
+// orientation o: flipped and/or rotated by a multiple of 90 degrees.
+func orient(m image.Image, o int) image.Image {
+	if o <= 1 || o > 8 {
+		return m
+	}
+	b := m.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if o >= 5 {
+		// the orientations with the axes swapped
+		dw, dh = h, w
+	}
+	// src returns the point of m shown at (x, y)
+	src := func(x, y int) (int, int) {
+		switch o {
+		case 2: // flipped horizontally
+			return w - 1 - x, y
+		case 3: // rotated 180
+			return w - 1 - x, h - 1 - y
+		case 4: // flipped vertically
+			return x, h - 1 - y
+		case 5: // transposed
+			return y, x
+		case 6: // rotated 90 clockwise
+			return y, h - 1 - x
+		case 7: // transversed
+			return w - 1 - y, h - 1 - x
+		default: // 8: rotated 90 counterclockwise
+			return w - 1 - y, x
+		}
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			sx, sy := src(x, y)
+			dst.Set(x, y, m.At(b.Min.X+sx, b.Min.Y+sy))
+		}
+	}
+	return dst
+}
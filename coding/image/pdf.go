@@ -0,0 +1,177 @@
+package image
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"io"
+	"os"
+)
+
+// A4 page size and margin, in points.
+const (
+	pdfPageWidth  = 595
+	pdfPageHeight = 842
+	pdfMargin     = 28
+)
+
+// EncodePdf writes to w a PDF document with a page for each image.
+// The images are printed at the given resolution, in dots per inch,
+// and reduced if needed to fit the A4 page.
+func EncodePdf(w io.Writer, pages []image.Image, dpi float64) error {
+	if dpi <= 0 {
+		dpi = 150
+	}
+	pw := &pdfWriter{w: bufio.NewWriter(w)}
+
+	// objects: 1 catalog, 2 pages, then page, content and image of each page
+	pw.printf("%%PDF-1.4\n%%\xe2\xe3\xcf\xd3\n")
+
+	pw.object(1, "<< /Type /Catalog /Pages 2 0 R >>")
+
+	kids := new(bytes.Buffer)
+	for j := range pages {
+		fmt.Fprintf(kids, "%d 0 R ", 3+3*j)
+	}
+	pw.object(2, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", kids, len(pages)))
+
+	for j, m := range pages {
+		page, content, img := 3+3*j, 4+3*j, 5+3*j
+		b := m.Bounds()
+
+		// size of the image on the page, in points
+		w := float64(b.Dx()) * 72 / dpi
+		h := float64(b.Dy()) * 72 / dpi
+		maxw, maxh := float64(pdfPageWidth-2*pdfMargin), float64(pdfPageHeight-2*pdfMargin)
+		if w > maxw {
+			w, h = maxw, h*maxw/w
+		}
+		if h > maxh {
+			w, h = w*maxh/h, maxh
+		}
+		// top left corner of the printable area
+		x, y := float64(pdfMargin), pdfPageHeight-pdfMargin-h
+
+		pw.object(page, fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] "+
+			"/Resources << /XObject << /Im0 %d 0 R >> >> /Contents %d 0 R >>",
+			pdfPageWidth, pdfPageHeight, img, content))
+
+		ops := fmt.Sprintf("q %.2f 0 0 %.2f %.2f %.2f cm /Im0 Do Q\n", w, h, x, y)
+		pw.stream(content, "", []byte(ops))
+
+		data, err := deflateRGB(m)
+		if err != nil {
+			return err
+		}
+		pw.stream(img, fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d "+
+			"/ColorSpace /DeviceRGB /BitsPerComponent 8 /Filter /FlateDecode ",
+			b.Dx(), b.Dy()), data)
+	}
+
+	// cross reference table
+	xref := pw.n
+	nobj := len(pw.offsets) + 1
+	pw.printf("xref\n0 %d\n0000000000 65535 f \n", nobj)
+	for j := 1; j < nobj; j++ {
+		pw.printf("%010d 00000 n \n", pw.offsets[j])
+	}
+	pw.printf("trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", nobj, xref)
+
+	if pw.err != nil {
+		return pw.err
+	}
+	return pw.w.Flush()
+}
+
+// SaveAsPdf saves the images as the pages of a PDF document.
+func SaveAsPdf(pages []image.Image, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = EncodePdf(f, pages, 150)
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+// pdfWriter writes the objects of a PDF document,
+// keeping their offsets. Only the first error is kept.
+type pdfWriter struct {
+	w       *bufio.Writer
+	n       int         // bytes written
+	offsets map[int]int // offset of each object
+	err     error
+}
+
+func (pw *pdfWriter) printf(format string, a ...interface{}) {
+	if pw.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(pw.w, format, a...)
+	pw.n += n
+	pw.err = err
+}
+
+func (pw *pdfWriter) write(p []byte) {
+	if pw.err != nil {
+		return
+	}
+	n, err := pw.w.Write(p)
+	pw.n += n
+	pw.err = err
+}
+
+// begin starts the object num.
+func (pw *pdfWriter) begin(num int) {
+	if pw.offsets == nil {
+		pw.offsets = map[int]int{}
+	}
+	pw.offsets[num] = pw.n
+	pw.printf("%d 0 obj\n", num)
+}
+
+// object writes the object num with the given content.
+func (pw *pdfWriter) object(num int, content string) {
+	pw.begin(num)
+	pw.printf("%s\nendobj\n", content)
+}
+
+// stream writes the stream object num, with the given
+// entries of the dictionary besides the length.
+func (pw *pdfWriter) stream(num int, dict string, data []byte) {
+	pw.begin(num)
+	pw.printf("<< %s/Length %d >>\nstream\n", dict, len(data))
+	pw.write(data)
+	pw.printf("\nendstream\nendobj\n")
+}
+
+// deflateRGB returns the RGB components of the pixels of m,
+// compressed with zlib. The transparent pixels are white.
+func deflateRGB(m image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	b := m.Bounds()
+	line := make([]byte, 3*b.Dx())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, a := m.At(x, y).RGBA()
+			// composite over white
+			bg := 0xffff - a
+			j := 3 * (x - b.Min.X)
+			line[j] = uint8((r + bg) >> 8)
+			line[j+1] = uint8((g + bg) >> 8)
+			line[j+2] = uint8((bl + bg) >> 8)
+		}
+		if _, err := zw.Write(line); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
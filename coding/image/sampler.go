@@ -0,0 +1,260 @@
+package image
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Footprint is the area of the source image covered by a cell
+// of the pixelated image, in fractional pixel coordinates:
+// the pixel (x, y) covers the area from (x, y) to (x+1, y+1).
+type Footprint struct {
+	X0, Y0, X1, Y1 float64
+}
+
+func (f Footprint) String() string {
+	return fmt.Sprintf("(%g,%g)-(%g,%g)", f.X0, f.Y0, f.X1, f.Y1)
+}
+
+// center returns the center of the footprint.
+func (f Footprint) center() (float64, float64) {
+	return (f.X0 + f.X1) / 2, (f.Y0 + f.Y1) / 2
+}
+
+// each calls fn for each pixel of b overlapping the footprint,
+// with the overlapping area as weight.
+// It returns an error if the footprint does not overlap b.
+func (f Footprint) each(b image.Rectangle, fn func(x, y int, w float64)) error {
+	x0, x1 := math.Max(f.X0, float64(b.Min.X)), math.Min(f.X1, float64(b.Max.X))
+	y0, y1 := math.Max(f.Y0, float64(b.Min.Y)), math.Min(f.Y1, float64(b.Max.Y))
+	if x0 >= x1 || y0 >= y1 {
+		return fmt.Errorf("footprint %v outside of the image %v", f, b)
+	}
+	for y := int(math.Floor(y0)); float64(y) < y1; y++ {
+		wy := math.Min(y1, float64(y+1)) - math.Max(y0, float64(y))
+		for x := int(math.Floor(x0)); float64(x) < x1; x++ {
+			wx := math.Min(x1, float64(x+1)) - math.Max(x0, float64(x))
+			fn(x, y, wx*wy)
+		}
+	}
+	return nil
+}
+
+// Sampler returns the color of a cell of the pixelated image
+// from the pixels of the source image m covered by the cell.
+// Any image type is accepted.
+type Sampler func(m image.Image, f Footprint) (color.Color, error)
+
+// colorSum accumulates weighted colors to compute their mean.
+// The components are weighted by the alpha of the color too,
+// so the transparent pixels do not darken the mean.
+type colorSum struct {
+	r, g, b, a, w float64
+	// linear averages the colors in linear light
+	// instead of in the (gamma encoded) sRGB space.
+	linear bool
+}
+
+func (s *colorSum) add(c color.Color, w float64) {
+	r, g, b, a := rgba(c)
+	s.w += w
+	if a == 0 {
+		return
+	}
+	aw := float64(a) / 255 * w
+	if s.linear {
+		s.r += linearize(r) * aw
+		s.g += linearize(g) * aw
+		s.b += linearize(b) * aw
+	} else {
+		s.r += float64(r) / 255 * aw
+		s.g += float64(g) / 255 * aw
+		s.b += float64(b) / 255 * aw
+	}
+	s.a += aw
+}
+
+// color returns the mean color. It is fully transparent
+// if all the colors are fully transparent.
+func (s *colorSum) color() color.Color {
+	if s.a == 0 {
+		return color.NRGBA{}
+	}
+	r, g, b := s.r/s.a, s.g/s.a, s.b/s.a
+	a := clamp8(s.a / s.w * 255)
+	if s.linear {
+		return color.NRGBA{delinearize(r), delinearize(g), delinearize(b), a}
+	}
+	return color.NRGBA{clamp8(r * 255), clamp8(g * 255), clamp8(b * 255), a}
+}
+
+// AverageColor returns the mean color of the image. The colors
+// are weighted by their alpha, and the mean is transparent as much
+// as the image is. If linear, the mean is computed in linear light,
+// as the eye would see the colors mixed from far away.
+func AverageColor(m image.Image, linear bool) color.Color {
+	s := colorSum{linear: linear}
+	b := m.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			s.add(m.At(x, y), 1)
+		}
+	}
+	return s.color()
+}
+
+// SampleNearest returns the color of the pixel at the center of the cell.
+func SampleNearest(m image.Image, f Footprint) (color.Color, error) {
+	b := m.Bounds()
+	cx, cy := f.center()
+	x, y := int(math.Floor(cx)), int(math.Floor(cy))
+	if !(image.Point{x, y}).In(b) {
+		return nil, fmt.Errorf("footprint %v outside of the image %v", f, b)
+	}
+	return m.At(x, y), nil
+}
+
+// SampleBox returns the mean color of the pixels covered by the cell,
+// each weighted by its covered area and by its alpha.
+func SampleBox(m image.Image, f Footprint) (color.Color, error) {
+	return sampleBox(m, f, false)
+}
+
+// SampleBoxLinear is like SampleBox, but the mean is computed
+// in linear light: the thin light details are not darkened.
+func SampleBoxLinear(m image.Image, f Footprint) (color.Color, error) {
+	return sampleBox(m, f, true)
+}
+
+func sampleBox(m image.Image, f Footprint, linear bool) (color.Color, error) {
+	s := colorSum{linear: linear}
+	err := f.each(m.Bounds(), func(x, y int, w float64) {
+		s.add(m.At(x, y), w)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.color(), nil
+}
+
+// SampleMedian returns the color whose components, alpha included,
+// are the (weighted) medians of the components of the pixels covered
+// by the cell. Unlike the mean, it is not affected by few outlier pixels.
+func SampleMedian(m image.Image, f Footprint) (color.Color, error) {
+	type value struct {
+		v uint8
+		w float64
+	}
+	var comps [4][]value
+	var total float64
+	err := f.each(m.Bounds(), func(x, y int, w float64) {
+		r, g, b, a := rgba(m.At(x, y))
+		for j, v := range [4]uint8{r, g, b, a} {
+			comps[j] = append(comps[j], value{v, w})
+		}
+		total += w
+	})
+	if err != nil {
+		return nil, err
+	}
+	var med [4]uint8
+	for j, vs := range comps {
+		sort.Slice(vs, func(a, b int) bool { return vs[a].v < vs[b].v })
+		var sum float64
+		for _, v := range vs {
+			sum += v.w
+			if sum >= total/2 {
+				med[j] = v.v
+				break
+			}
+		}
+	}
+	if med[3] == 0 {
+		return color.NRGBA{}, nil
+	}
+	return color.NRGBA{med[0], med[1], med[2], med[3]}, nil
+}
+
+// SampleMode returns the color covering the largest area of the cell.
+// It keeps the colors of the source image, and is the best choice
+// for images with few flat colors, like pixel art.
+func SampleMode(m image.Image, f Footprint) (color.Color, error) {
+	areas := map[color.NRGBA64]float64{}
+	var best color.NRGBA64
+	bestArea := -1.0
+	err := f.each(m.Bounds(), func(x, y int, w float64) {
+		c := color.NRGBA64Model.Convert(m.At(x, y)).(color.NRGBA64)
+		if c.A == 0 {
+			// all the transparent pixels are the same color
+			c = color.NRGBA64{}
+		}
+		areas[c] += w
+		// the first color wins the ties
+		if areas[c] > bestArea {
+			best, bestArea = c, areas[c]
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return best, nil
+}
+
+// GaussianSampler returns a sampler computing the mean color of the
+// pixels around the cell, each weighted by a gaussian centered in the
+// cell. The standard deviation is sigma times the size of the cell.
+// Compared to SampleBox, it gives smoother results.
+// If linear, the mean is computed in linear light.
+func GaussianSampler(sigma float64, linear bool) Sampler {
+	if sigma <= 0 {
+		sigma = 0.5
+	}
+	return func(m image.Image, f Footprint) (color.Color, error) {
+		cx, cy := f.center()
+		sx, sy := sigma*(f.X1-f.X0), sigma*(f.Y1-f.Y0)
+		// the pixels within two standard deviations
+		area := Footprint{cx - 2*sx, cy - 2*sy, cx + 2*sx, cy + 2*sy}
+		s := colorSum{linear: linear}
+		err := area.each(m.Bounds(), func(x, y int, w float64) {
+			dx, dy := (float64(x)+0.5-cx)/sx, (float64(y)+0.5-cy)/sy
+			s.add(m.At(x, y), w*math.Exp(-(dx*dx+dy*dy)/2))
+		})
+		if err != nil {
+			return nil, err
+		}
+		if s.w == 0 {
+			return SampleNearest(m, f)
+		}
+		return s.color(), nil
+	}
+}
+
+// SamplerNames are the names of the samplers returned by NewSampler.
+var SamplerNames = []string{"nearest", "box", "box-linear", "median", "mode", "gaussian", "gaussian-linear", "detail"}
+
+// NewSampler returns the sampler with the given name.
+func NewSampler(name string) (Sampler, error) {
+	switch strings.ToLower(name) {
+	case "nearest":
+		return SampleNearest, nil
+	case "box":
+		return SampleBox, nil
+	case "box-linear":
+		return SampleBoxLinear, nil
+	case "median":
+		return SampleMedian, nil
+	case "mode":
+		return SampleMode, nil
+	case "gaussian":
+		return GaussianSampler(0.5, false), nil
+	case "gaussian-linear":
+		return GaussianSampler(0.5, true), nil
+	case "detail":
+		return DetailSampler(0.5), nil
+	}
+	return nil, fmt.Errorf("unknown sampler %q", name)
+}
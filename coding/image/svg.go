@@ -0,0 +1,110 @@
+package image
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"os"
+)
+
+// SvgClass is a CSS class of an svg image, with its fill color.
+type SvgClass struct {
+	Name  string
+	Color color.Color
+}
+
+// SvgRun is an horizontal run of N cells, starting from (X, Y),
+// drawn with the CSS class of index Class.
+type SvgRun struct {
+	X, Y, N int
+	Class   int
+}
+
+// EncodeSvg writes to w an svg image of width x height cells,
+// each one 1 unit wide, drawing a rect for each run.
+// The cells without runs are transparent.
+func EncodeSvg(w io.Writer, width, height int, classes []SvgClass, runs []SvgRun) error {
+	return EncodeScaledSvg(w, width, height, 1, 1, classes, runs)
+}
+
+// EncodeScaledSvg is like EncodeSvg, but the cells are displayed
+// cellW x cellH pixels: the rects keep the coordinates of the cells,
+// and the image is stretched to its size.
+func EncodeScaledSvg(w io.Writer, width, height int, cellW, cellH float64, classes []SvgClass, runs []SvgRun) error {
+	bw := bufio.NewWriter(w)
+
+	var stretch string
+	if cellW != cellH {
+		stretch = " preserveAspectRatio=\"none\""
+	}
+	fmt.Fprintf(bw, "<svg xmlns=\"http://www.w3.org/2000/svg\" viewBox=\"0 0 %d %d\" "+
+		"width=\"%g\" height=\"%g\"%s shape-rendering=\"crispEdges\">\n",
+		width, height, float64(width)*cellW, float64(height)*cellH, stretch)
+
+	fmt.Fprintf(bw, "<style>\n")
+	for _, c := range classes {
+		r, g, b, a := rgba(c.Color)
+		fmt.Fprintf(bw, ".%s { fill: #%02x%02x%02x;", c.Name, r, g, b)
+		if a != 255 {
+			fmt.Fprintf(bw, " fill-opacity: %.3g;", float64(a)/255)
+		}
+		fmt.Fprintf(bw, " }\n")
+	}
+	fmt.Fprintf(bw, "</style>\n")
+
+	for _, r := range runs {
+		fmt.Fprintf(bw, "<rect class=\"%s\" x=\"%d\" y=\"%d\" width=\"%d\" height=\"1\"/>\n",
+			classes[r.Class].Name, r.X, r.Y, r.N)
+	}
+	fmt.Fprintf(bw, "</svg>\n")
+
+	return bw.Flush()
+}
+
+// PalettedRuns returns the runs of the pixels of the same color index
+// of each row of the image. The fully transparent pixels are skipped.
+// The coordinates are relative to the top left corner of the image.
+func PalettedRuns(m *image.Paletted) []SvgRun {
+	var runs []SvgRun
+	b := m.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; {
+			idx := m.ColorIndexAt(x, y)
+			n := 1
+			for x+n < b.Max.X && m.ColorIndexAt(x+n, y) == idx {
+				n++
+			}
+			if int(idx) < len(m.Palette) && !isTransparent(m.Palette[idx]) {
+				runs = append(runs, SvgRun{x - b.Min.X, y - b.Min.Y, n, int(idx)})
+			}
+			x += n
+		}
+	}
+	return runs
+}
+
+// EncodePalettedSvg writes to w the paletted image in the svg format,
+// with a CSS class for each color of the palette.
+func EncodePalettedSvg(w io.Writer, m *image.Paletted) error {
+	classes := make([]SvgClass, len(m.Palette))
+	for j, c := range m.Palette {
+		classes[j] = SvgClass{fmt.Sprintf("c%d", j), c}
+	}
+	b := m.Bounds()
+	return EncodeSvg(w, b.Dx(), b.Dy(), classes, PalettedRuns(m))
+}
+
+// SaveAsSvg saves the paletted image in the svg format.
+func SaveAsSvg(m *image.Paletted, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = EncodePalettedSvg(f, m)
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	return err
+}
@@ -0,0 +1,225 @@
+package image
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// ZoomOptions are the options of ZoomWith.
+type ZoomOptions struct {
+	// CellW and CellH are the dimensions of a cell in pixels.
+	// They can be fractional: the borders of the cells are rounded
+	// to the nearest pixel. If CellH is 0, it is equal to CellW.
+	CellW, CellH float64
+	// GridWidth is the width in pixels of the gridlines
+	// between the cells, of color GridColor (default gray).
+	GridWidth int
+	GridColor color.Color
+	// MajorEvery, if positive, draws a major gridline every MajorEvery
+	// cells, MajorWidth pixels wide (default GridWidth+1),
+	// of color MajorColor (default black).
+	MajorEvery int
+	MajorWidth int
+	MajorColor color.Color
+	// Border is the width in pixels of the border around the image,
+	// of color BorderColor (default black).
+	Border      int
+	BorderColor color.Color
+	// Background, if not nil, is the color the transparent
+	// cells are drawn over, like the null color of a coding.
+	Background color.Color
+	// Checkerboard, if positive, is the side in pixels of the squares
+	// of the checkerboard the not opaque cells are drawn over.
+	// It takes the precedence over Background.
+	Checkerboard int
+}
+
+// The colors of the checkerboard of the not opaque cells.
+var (
+	checkerLight = color.NRGBA{255, 255, 255, 255}
+	checkerDark  = color.NRGBA{204, 204, 204, 255}
+)
+
+// ZoomLayout is the position of the cells in a zoomed image.
+// It maps the cells to the pixels and back.
+type ZoomLayout struct {
+	// the first and the last+1 pixel of each column and row
+	x0, x1, y0, y1 []int
+	w, h           int
+}
+
+// Size returns the dimensions of the zoomed image.
+func (l *ZoomLayout) Size() (int, int) {
+	return l.w, l.h
+}
+
+// Cell returns the pixels of the cell (x, y).
+func (l *ZoomLayout) Cell(x, y int) image.Rectangle {
+	return image.Rect(l.x0[x], l.y0[y], l.x1[x], l.y1[y])
+}
+
+// CellAt returns the cell containing the pixel (px, py).
+// It returns false if the pixel is on a gridline or on the border.
+func (l *ZoomLayout) CellAt(px, py int) (int, int, bool) {
+	x, okx := cellIndex(l.x0, l.x1, px)
+	y, oky := cellIndex(l.y0, l.y1, py)
+	return x, y, okx && oky
+}
+
+func cellIndex(start, end []int, p int) (int, bool) {
+	// binary search of the first cell ending after p
+	lo, hi := 0, len(end)
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if end[mid] <= p {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	if lo == len(end) || p < start[lo] {
+		return 0, false
+	}
+	return lo, true
+}
+
+// zoomAxis returns the first and the last+1 pixel of each of the n cells
+// of size cell along an axis, and the size of the axis.
+func zoomAxis(n int, cell float64, opt *ZoomOptions) ([]int, []int, int) {
+	start, end := make([]int, n), make([]int, n)
+	lines := 0
+	for k := 0; k < n; k++ {
+		if k > 0 {
+			lines += opt.lineWidth(k)
+		}
+		start[k] = opt.Border + lines + int(math.Round(float64(k)*cell))
+		end[k] = opt.Border + lines + int(math.Round(float64(k+1)*cell))
+	}
+	size := 2 * opt.Border
+	if n > 0 {
+		size = end[n-1] + opt.Border
+	}
+	return start, end, size
+}
+
+// isMajor reports whether the gridline before the cell k is a major one.
+func (opt *ZoomOptions) isMajor(k int) bool {
+	return opt.MajorEvery > 0 && k%opt.MajorEvery == 0
+}
+
+// lineWidth returns the width of the gridline before the cell k.
+func (opt *ZoomOptions) lineWidth(k int) int {
+	if opt.isMajor(k) {
+		if opt.MajorWidth > 0 {
+			return opt.MajorWidth
+		}
+		return opt.GridWidth + 1
+	}
+	return opt.GridWidth
+}
+
+// NewZoomLayout returns the layout of the cells of an image
+// of cols x rows cells, zoomed with the given options.
+func NewZoomLayout(cols, rows int, opt ZoomOptions) *ZoomLayout {
+	if opt.CellH <= 0 {
+		opt.CellH = opt.CellW
+	}
+	l := &ZoomLayout{}
+	l.x0, l.x1, l.w = zoomAxis(cols, opt.CellW, &opt)
+	l.y0, l.y1, l.h = zoomAxis(rows, opt.CellH, &opt)
+	return l
+}
+
+// ZoomWith returns the image m zoomed with the given options, and the
+// layout of its cells. If m is a *image.Paletted, the zoomed image is
+// a *image.Paletted too, with the colors of the gridlines and of the
+// border appended to the palette, unless they are more than 256.
+func ZoomWith(m image.Image, opt ZoomOptions) (image.Image, *ZoomLayout, error) {
+	if opt.CellW < 1 || (opt.CellH != 0 && opt.CellH < 1) {
+		return nil, nil, errors.New("Zoom: the cells must be at least one pixel")
+	}
+	if opt.GridWidth < 0 || opt.MajorWidth < 0 || opt.Border < 0 {
+		return nil, nil, errors.New("Zoom: negative width of a line")
+	}
+	b := m.Bounds()
+	if b.Empty() {
+		return nil, nil, errors.New("Zoom: empty image")
+	}
+	gridColor, majorColor, borderColor := opt.GridColor, opt.MajorColor, opt.BorderColor
+	if gridColor == nil {
+		gridColor = color.Gray{160}
+	}
+	if majorColor == nil {
+		majorColor = color.Black
+	}
+	if borderColor == nil {
+		borderColor = color.Black
+	}
+
+	l := NewZoomLayout(b.Dx(), b.Dy(), opt)
+	g := image.NewNRGBA(image.Rect(0, 0, l.w, l.h))
+	fill := func(r image.Rectangle, c color.Color) {
+		draw.Draw(g, r, image.NewUniform(c), image.Point{}, draw.Src)
+	}
+
+	// the border, then the gridlines, then the cells over them
+	fill(g.Rect, borderColor)
+	fill(image.Rect(opt.Border, opt.Border, l.w-opt.Border, l.h-opt.Border), gridColor)
+	for k := 1; k < b.Dx(); k++ {
+		if opt.isMajor(k) {
+			fill(image.Rect(l.x1[k-1], opt.Border, l.x0[k], l.h-opt.Border), majorColor)
+		}
+	}
+	for k := 1; k < b.Dy(); k++ {
+		if opt.isMajor(k) {
+			fill(image.Rect(opt.Border, l.y1[k-1], l.w-opt.Border, l.y0[k]), majorColor)
+		}
+	}
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			c := color.NRGBAModel.Convert(m.At(b.Min.X+x, b.Min.Y+y)).(color.NRGBA)
+			r := l.Cell(x, y)
+			switch {
+			case c.A == 255:
+				fill(r, c)
+			case opt.Checkerboard > 0:
+				for py := r.Min.Y; py < r.Max.Y; py++ {
+					for px := r.Min.X; px < r.Max.X; px++ {
+						under := checkerLight
+						if (px/opt.Checkerboard+py/opt.Checkerboard)%2 == 1 {
+							under = checkerDark
+						}
+						g.SetNRGBA(px, py, over(c, under))
+					}
+				}
+			case opt.Background != nil:
+				fill(r, over(c, color.NRGBAModel.Convert(opt.Background).(color.NRGBA)))
+			default:
+				fill(r, c)
+			}
+		}
+	}
+
+	if mp, ok := m.(*image.Paletted); ok {
+		if imgpal, ok := exactPaletted(g, mp.Palette, 256); ok {
+			return imgpal, l, nil
+		}
+	}
+	return g, l, nil
+}
+
+// over returns the color c composed over the color under.
+func over(c, under color.NRGBA) color.NRGBA {
+	a, ua := float64(c.A)/255, float64(under.A)/255
+	oa := a + ua*(1-a)
+	if oa == 0 {
+		return color.NRGBA{}
+	}
+	mix := func(v, u uint8) uint8 {
+		return clamp8((float64(v)*a + float64(u)*ua*(1-a)) / oa)
+	}
+	return color.NRGBA{mix(c.R, under.R), mix(c.G, under.G), mix(c.B, under.B), clamp8(oa * 255)}
+}
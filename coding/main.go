@@ -1,169 +1,197 @@
 package main
 
 import (
-	"fmt"
 	"image"
+	"image/color"
+	"image/draw"
 	"log"
 	"os"
+	"strconv"
+	"strings"
 
 	codimg "github.com/mmbros/test/coding/image"
 )
 
-func paletted2coding(imgpal *image.Paletted) (*Coding, error) {
-
-	colorName := func(idx int) string {
-		return string(97 + idx)
+// colorKeys returns the keys of the colors of the palette.
+// Each key is the name of the nearest named color, made unique
+// by a numeric suffix ("rosso", "rosso2", ...) and valid
+// for the legend of a coding file.
+func colorKeys(pal color.Palette) []string {
+	keys := make([]string, len(pal))
+	used := map[string]bool{}
+
+	for j, c := range pal {
+		name := validKey(codimg.NearestColorName(c))
+		key := name
+		for n := 2; used[key]; n++ {
+			key = name + strconv.Itoa(n)
+		}
+		used[key] = true
+		keys[j] = key
 	}
+	return keys
+}
 
-	cod := NewCoding()
+// validKey converts the name to a valid key of the legend.
+func validKey(name string) string {
+	key := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '_':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		}
+		return '_'
+	}, strings.TrimSpace(name))
 
-	// create the coding.Palette
-	for j, c := range imgpal.Palette {
-		cod.pal.Add(colorName(j), c)
+	if !reColorName.MatchString(key) {
+		key = "c" + key
 	}
+	return key
+}
 
-	r := imgpal.Bounds()
+// imagePalette returns the palette of the image and a function
+// returning the palette index of the color at (x, y).
+// If the image is not paletted, the palette contains
+// the distinct colors of the image in order of appearance.
+func imagePalette(m image.Image) (color.Palette, func(x, y int) int) {
+	if imgpal, ok := m.(*image.Paletted); ok {
+		return imgpal.Palette, func(x, y int) int {
+			return int(imgpal.ColorIndexAt(x, y))
+		}
+	}
 
+	var pal color.Palette
+	c2i := map[color.NRGBA]int{}
+	r := m.Bounds()
 	for y := r.Min.Y; y < r.Max.Y; y++ {
-		row := ProgramRow{}
-
-		var prec, count uint8
-
 		for x := r.Min.X; x < r.Max.X; x++ {
-			idx := imgpal.ColorIndexAt(x, y)
-			if idx == prec {
-				count++
-			} else {
-				if count > 0 {
-					item := ProgramItem{
-						n: int(count),
-						k: colorName(int(prec)),
-					}
-					row = append(row, &item)
-				}
-				prec = idx
-				count = 1
-			}
-		}
-		if count > 0 {
-			item := ProgramItem{
-				n: int(count),
-				k: colorName(int(prec)),
+			c := color.NRGBAModel.Convert(m.At(x, y)).(color.NRGBA)
+			if _, ok := c2i[c]; !ok {
+				c2i[c] = len(pal)
+				pal = append(pal, c)
 			}
-			row = append(row, &item)
 		}
-		cod.prog = append(cod.prog, row)
 	}
-	//for y := r.Min.Y; y < r.Max.Y; y++ {
-	//	row := ProgramRow{}
-
-	//	var prec, count uint8
-
-	//	for x := r.Min.X; x < r.Max.X; x++ {
-	//		idx := imgpal.ColorIndexAt(x, y)
-	//		if idx == prec {
-	//			count++
-	//		} else {
-	//			if count > 0 {
-	//				item := ProgramItem{
-	//					n: int(count),
-	//					k: colorName(int(prec)),
-	//				}
-	//				row = append(row, &item)
-	//			}
-	//			prec = idx
-	//			count = 1
-	//		}
-	//	}
-	//	if count > 0 {
-	//		item := ProgramItem{
-	//			n: int(count),
-	//			k: colorName(int(prec)),
-	//		}
-	//		row = append(row, &item)
-	//	}
-	//	cod.prog.Add(row)
-	//}
-
-	return cod, nil
+	return pal, func(x, y int) int {
+		return c2i[color.NRGBAModel.Convert(m.At(x, y)).(color.NRGBA)]
+	}
 }
 
-func saveCodingOld(path string, imgpal *image.Paletted) error {
+// image2coding returns the coding of the image.
+// There is no limit to the number of colors of the image.
+func image2coding(m image.Image) (*Coding, error) {
 
-	// outputFile is a File type which satisfies Writer interface
-	w, err := os.Create(path)
-	if err != nil {
-		return err
-	}
-	defer w.Close()
+	pal, colorIndexAt := imagePalette(m)
+	keys := colorKeys(pal)
 
-	colorName := func(idx int) string {
-		return string(97 + idx)
-	}
+	cod := NewCoding()
 
-	fmt.Fprintf(w, "// Palette\n\n")
-	for j, c := range imgpal.Palette {
-		fmt.Fprintf(w, "%s: rgb(%v)\n", colorName(j), c)
+	// create the coding.Palette
+	for j, c := range pal {
+		cod.pal.Add(keys[j], c)
 	}
 
-	r := imgpal.Bounds()
-	fmt.Fprintf(w, "\n# Image (%d x %d)\n\n", r.Dx(), r.Dy())
+	r := m.Bounds()
 
 	for y := r.Min.Y; y < r.Max.Y; y++ {
-		fmt.Fprintf(w, "%d:", y+1)
+		row := ProgramRow{}
 
-		var prec, count uint8
+		var prec, count int
 
 		for x := r.Min.X; x < r.Max.X; x++ {
-			idx := imgpal.ColorIndexAt(x, y)
-			if idx == prec {
+			idx := colorIndexAt(x, y)
+			if count > 0 && idx == prec {
 				count++
 			} else {
 				if count > 0 {
-					fmt.Fprintf(w, " %d%s", count, colorName(int(prec)))
+					row = append(row, &ProgramItem{n: count, k: keys[prec]})
 				}
 				prec = idx
 				count = 1
 			}
 		}
 		if count > 0 {
-			fmt.Fprintf(w, " %d%s", count, colorName(int(prec)))
+			row = append(row, &ProgramItem{n: count, k: keys[prec]})
 		}
-		fmt.Fprint(w, "\n")
+		cod.prog = append(cod.prog, row)
 	}
 
-	return nil
+	return cod, nil
+}
+
+// setCatalogReferences records in the legend of the coding
+// the references to the catalog colors of the palette.
+func setCatalogReferences(cod *Coding, cat *codimg.Catalog) {
+	for _, k := range cod.pal.i2k {
+		c, _ := cod.pal.ByKey(k)
+		if cc, ok := cat.Find(c); ok {
+			cod.pal.setReference(k, cat.Reference(cc))
+		}
+	}
 }
 
-func txt2png(pathTxt, pathPng string) error {
-	cod := NewCoding()
+func paletted2coding(imgpal *image.Paletted) (*Coding, error) {
+	return image2coding(imgpal)
+}
 
-	err := cod.Read(pathTxt)
+// txt2png renders the coding file as a png image, zoomed with the given
+// options; the height of the cells is computed from their width and the
+// aspect. If aspect is zero, the aspect of the header is used.
+func txt2png(pathTxt, pathPng string, opt codimg.ZoomOptions, aspect codimg.Aspect, progress bool) error {
+	cod, err := readCoding(pathTxt, false)
 	if err != nil {
 		return err
 	}
-	cod.Print()
-	z := 6
-	img, err := codimg.Zoom(cod.Image(), z, z)
+	if aspect == (codimg.Aspect{}) {
+		aspect = cod.hdr.Aspect
+	}
+	opt.CellH = opt.CellW / aspect.Ratio()
+	if opt.CellH < 1 {
+		opt.CellH = 1
+	}
+	img, layout, err := codimg.ZoomWith(cod.Image(), opt)
 	if err != nil {
 		return err
 	}
+	if progress {
+		p, err := LoadProgress(pathTxt+ProgressExt, cod)
+		if err != nil {
+			return err
+		}
+		// the highlight color is not in the palette
+		m := image.NewNRGBA(img.Bounds())
+		draw.Draw(m, m.Rect, img, img.Bounds().Min, draw.Src)
+		p.highlight(m, layout.Cell, m.Bounds())
+		img = m
+	}
 	err = codimg.SaveAsPng(img, pathPng)
 	return err
 }
 
 func main() {
-	//err := txt2png("doc/mistero.txt", "img/mistero.png")
-	err := txt2png("doc/pokemon.txt", "img/pok.png")
-	if err != nil {
-		log.Fatal(err)
+	log.SetFlags(0)
+	log.SetPrefix("coding: ")
+
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
 	}
-}
 
-func main2() {
-	imgpal := codimg.Pokemon()
-	cod, _ := paletted2coding(imgpal)
-	cod.Print()
-	cod.SaveAs("doc/pokemon.txt")
+	cmd := findCommand(os.Args[1])
+	if cmd == nil {
+		log.Printf("unknown command %q", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
 
+	if err := cmd.run(os.Args[2:]); err != nil {
+		if list, ok := err.(ErrorList); ok {
+			for _, e := range list {
+				log.Print(e)
+			}
+			os.Exit(1)
+		}
+		log.Fatal(err)
+	}
 }
@@ -14,6 +14,10 @@ type Palette struct {
 	m   map[string]color.Color
 	i2k []string
 	k2i map[string]int
+	// original notation of the colors read from a coding file
+	src map[string]string
+	// reference to the catalog color of the key, like "dmc 310"
+	refs map[string]string
 }
 
 // NewPalette returns a new MapPalette object
@@ -22,6 +26,8 @@ func NewPalette() *Palette {
 		map[string]color.Color{},
 		[]string{},
 		map[string]int{},
+		map[string]string{},
+		map[string]string{},
 	}
 }
 
@@ -35,6 +41,25 @@ func (mp *Palette) Add(name string, col color.Color) {
 	}
 }
 
+// setNotation sets the notation used to write the color of the key,
+// in place of the one returned by codimg.ToString.
+func (mp *Palette) setNotation(name, notation string) {
+	if _, ok := mp.src[name]; !ok {
+		mp.src[name] = notation
+	}
+}
+
+// setReference sets the reference to the catalog color of the key.
+func (mp *Palette) setReference(name, ref string) {
+	mp.refs[name] = ref
+}
+
+// Reference returns the reference to the catalog color of the key,
+// like "dmc 310", or an empty string.
+func (mp *Palette) Reference(name string) string {
+	return mp.refs[name]
+}
+
 // HasKey returns true if the palette has a color with the given key.
 func (mp *Palette) HasKey(name string) bool {
 	_, ok := mp.m[name]
@@ -81,11 +106,23 @@ func (mp *Palette) Palette() color.Palette {
 	return p
 }
 
+// row returns the legend row of the key.
+func (mp *Palette) row(k string) string {
+	notation, ok := mp.src[k]
+	if !ok {
+		notation = codimg.ToString(mp.m[k])
+		if ref := mp.refs[k]; ref != "" {
+			notation += " (" + ref + ")"
+		}
+	}
+	return k + " = " + notation
+}
+
 // Fprint writes to w a representation of the palette.
 // The output format can be readed back in the coding file.
 func (mp *Palette) Fprint(w io.Writer) {
 	for _, k := range mp.i2k {
-		fmt.Fprintf(w, "%s = %s\n", k, codimg.ToString(mp.m[k]))
+		fmt.Fprintln(w, mp.row(k))
 	}
 }
 
@@ -93,3 +130,27 @@ func (mp *Palette) Fprint(w io.Writer) {
 func (mp *Palette) Print() {
 	mp.Fprint(os.Stdout)
 }
+
+// confusablePair is a pair of keys of the palette whose colors are hard
+// to distinguish for a person with a color vision deficiency.
+type confusablePair struct {
+	k1, k2 string
+	codimg.ConfusablePair
+}
+
+func (p confusablePair) String() string {
+	return fmt.Sprintf("%s and %s (%s, distance %.1f)", p.k1, p.k2, p.Deficiency, p.Distance)
+}
+
+// confusablePairs returns the pairs of colors of the palette closer than
+// threshold for color blind people. If threshold is 0, no pair is returned.
+func (mp *Palette) confusablePairs(threshold float64) []confusablePair {
+	if threshold <= 0 {
+		return nil
+	}
+	var pairs []confusablePair
+	for _, p := range codimg.ConfusablePairs(mp.Palette(), threshold) {
+		pairs = append(pairs, confusablePair{mp.i2k[p.I], mp.i2k[p.J], p})
+	}
+	return pairs
+}
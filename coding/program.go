@@ -12,17 +12,28 @@ import (
 type ProgramItem struct {
 	n int
 	k string
+
+	// position of the item in the coding file, if any
+	line, col int
 }
 
+// newProgramItem parses an item in the form "<count><key>".
+// The count is optional and defaults to 1; since the key begins
+// with a letter, it can end with digits (as in "3rosso2").
 func newProgramItem(s string) *ProgramItem {
 	var p ProgramItem
-	j := strings.LastIndexAny(s, "0123456789")
+	j := strings.IndexFunc(s, func(r rune) bool {
+		return r < '0' || r > '9'
+	})
 	if j < 0 {
+		j = len(s)
+	}
+	if j == 0 {
 		p.n = 1
 		p.k = s
 	} else {
-		p.n, _ = strconv.Atoi(s[:j+1])
-		p.k = s[j+1:]
+		p.n, _ = strconv.Atoi(s[:j])
+		p.k = s[j:]
 	}
 	return &p
 }
@@ -54,32 +65,139 @@ func (pr ProgramRow) Len() int {
 	return n
 }
 
-// Add adds a new row in a program
+// Add adds a new row in a program.
+// The returned error is a *ParseError or an ErrorList.
 func (p *Program) Add(row string) error {
-	r := ProgramRow{}
+	return p.add(srcLine{text: row})
+}
 
-	for _, v := range strings.Fields(row) {
-		pi := newProgramItem(v)
-		if pi.n == 0 {
-			return fmt.Errorf("Invalid program item %q at row #%d", v, len(*p)+1)
-		} else if pi.k == "" {
-			return fmt.Errorf("Invalid program item %q at row #%d: missing color", v, len(*p)+1)
+func (p *Program) add(l srcLine) error {
+	r, err := parseItems(l)
+	if err != nil {
+		setErrorRow(err, len(*p)+1)
+		return err
+	}
+	*p = append(*p, r)
+	return nil
+}
 
+// addStmt adds the rows of the statement.
+func (p *Program) addStmt(stmt programStmt) error {
+	if stmt.ref > 0 {
+		if stmt.refEnd > len(*p) {
+			return stmt.refTok.error(InvalidReference, 0, stmt.refTok.text, nil)
 		}
-		r = append(r, pi)
-	}
-	if len(r) == 0 {
-		return fmt.Errorf("Invalid program at row #%d", len(*p)+1)
+		for j := 0; j <= stmt.last-stmt.first; j++ {
+			src := stmt.ref - 1
+			if stmt.refEnd > stmt.ref {
+				if stmt.mirror {
+					src = stmt.refEnd - 1 - j
+				} else {
+					src += j
+				}
+			}
+			*p = append(*p, ProgramRow(copyItems((*p)[src])))
+		}
+		return nil
 	}
 
-	*p = append(*p, r)
+	r, err := parseItems(stmt.items)
+	if err != nil {
+		setErrorRow(err, stmt.first)
+		return err
+	}
+	for j := stmt.first; j <= stmt.last; j++ {
+		*p = append(*p, ProgramRow(copyItems(r)))
+	}
 	return nil
 }
 
+// setErrorRow sets the program row of the errors in err.
+func setErrorRow(err error, row int) {
+	switch e := err.(type) {
+	case *ParseError:
+		e.Row = row
+	case ErrorList:
+		for _, pe := range e {
+			pe.Row = row
+		}
+	}
+}
+
+// isMirror reports whether the row is symmetric left to right,
+// that is an odd number of items equal to the reversed items.
+func (r ProgramRow) isMirror() bool {
+	n := len(r)
+	if n%2 == 0 {
+		return false
+	}
+	for j := 0; j < n/2; j++ {
+		if r[j].n != r[n-1-j].n || r[j].k != r[n-1-j].k {
+			return false
+		}
+	}
+	return true
+}
+
+// isMirror reports whether the program is symmetric top to bottom.
+func (p Program) isMirror() bool {
+	n := len(p)
+	for j := 0; j < n/2; j++ {
+		if !equalItems(p[j], p[n-1-j]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Symmetry returns the symmetry of the image generated by the program:
+// SymmetryBoth, SymmetryLeftRight, SymmetryTopBottom,
+// or an empty string if the image is not symmetric.
+func (p Program) Symmetry() string {
+	if len(p) < 2 {
+		return ""
+	}
+	lr := true
+	for _, r := range p {
+		if !r.isMirror() || r.Len() != p[0].Len() {
+			lr = false
+			break
+		}
+	}
+	tb := p.isMirror()
+	switch {
+	case lr && tb:
+		return SymmetryBoth
+	case lr:
+		return SymmetryLeftRight
+	case tb:
+		return SymmetryTopBottom
+	}
+	return ""
+}
+
+// text returns the representation of n rows starting from row j,
+// used to check if the rows were modified.
+func (p Program) text(j, n int) string {
+	if j+n > len(p) {
+		return ""
+	}
+	var a []string
+	for _, r := range p[j : j+n] {
+		a = append(a, r.String())
+	}
+	return strings.Join(a, "\n")
+}
+
+// row returns the representation of the j-th row (0-based) of the Program.
+func (p Program) row(j int) string {
+	return fmt.Sprintf("%d = %s", j+1, p[j].String())
+}
+
 // Fprint writes to w a representation of the Program.
 func (p Program) Fprint(w io.Writer) {
-	for j, r := range p {
-		fmt.Fprintf(w, "%d = %s\n", j+1, r.String())
+	for j := range p {
+		fmt.Fprintln(w, p.row(j))
 	}
 }
 
@@ -101,14 +219,35 @@ func (p Program) Size() (int, int) {
 	return cols, len(p)
 }
 
-// CheckColors is ...
+// CheckColors checks that every color used by the program
+// is defined in the palette. It reports all the unknown colors
+// as a *ParseError or an ErrorList.
 func (p Program) CheckColors(mp *Palette) error {
+	var errs ErrorList
+	// the items repeated by a group or by a range of rows
+	// are reported once, in their first row
+	type position struct{ line, col int }
+	seen := map[position]bool{}
 	for rownum, r := range p {
 		for _, i := range r {
-			if !mp.HasKey(i.k) {
-				return fmt.Errorf("Unknown  color %q at row #%d", i.k, rownum+1)
+			if mp.HasKey(i.k) {
+				continue
 			}
+			if i.line > 0 {
+				pos := position{i.line, i.col}
+				if seen[pos] {
+					continue
+				}
+				seen[pos] = true
+			}
+			errs.Add(&ParseError{
+				Line:   i.line,
+				Column: i.col,
+				Row:    rownum + 1,
+				Token:  i.k,
+				Kind:   UnknownColor,
+			})
 		}
 	}
-	return nil
+	return errs.Err()
 }
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Progress is the position of the crafter working through a coding:
+// the items before the current one are completed.
+// A progress can be saved alongside the coding file,
+// in a file with the ProgressExt extension.
+type Progress struct {
+	cod  *Coding
+	Row  int // current row of the program, 0-based
+	Item int // current item of the row, 0-based
+}
+
+// ProgressExt is the extension appended to the name of the coding file
+// to get the name of its progress file.
+const ProgressExt = ".progress"
+
+// progressHighlight is the color of the frame of the current row.
+var progressHighlight = color.NRGBA{0xff, 0x8c, 0x00, 0xff}
+
+// NewProgress returns the progress of the coding at its first item.
+func NewProgress(cod *Coding) *Progress {
+	return &Progress{cod: cod}
+}
+
+// Done reports whether all the rows are completed.
+func (p *Progress) Done() bool {
+	return p.Row >= len(p.cod.prog)
+}
+
+// Current returns the current item, or nil if the work is done.
+func (p *Progress) Current() *ProgramItem {
+	if p.Done() {
+		return nil
+	}
+	return p.cod.prog[p.Row][p.Item]
+}
+
+// Next completes n items, moving to the next rows if needed.
+func (p *Progress) Next(n int) {
+	for ; n > 0 && !p.Done(); n-- {
+		p.Item++
+		if p.Item >= len(p.cod.prog[p.Row]) {
+			p.Row++
+			p.Item = 0
+		}
+	}
+}
+
+// Prev goes back by n items.
+func (p *Progress) Prev(n int) {
+	for ; n > 0 && (p.Row > 0 || p.Item > 0); n-- {
+		if p.Item > 0 {
+			p.Item--
+			continue
+		}
+		p.Row--
+		p.Item = len(p.cod.prog[p.Row]) - 1
+	}
+}
+
+// Seek moves to the first item of the row (0-based).
+func (p *Progress) Seek(row int) error {
+	if row < 0 || row > len(p.cod.prog) {
+		return fmt.Errorf("invalid row #%d", row+1)
+	}
+	p.Row, p.Item = row, 0
+	return nil
+}
+
+// Completed returns the number of completed cells of each palette key.
+func (p *Progress) Completed() map[string]int {
+	m := map[string]int{}
+	for y, row := range p.cod.prog {
+		for j, item := range row {
+			if y > p.Row || (y == p.Row && j >= p.Item) {
+				return m
+			}
+			m[item.k] += item.n
+		}
+	}
+	return m
+}
+
+// Remaining returns the number of cells still to do of each palette key.
+func (p *Progress) Remaining() map[string]int {
+	done := p.Completed()
+	m := map[string]int{}
+	for _, row := range p.cod.prog {
+		for _, item := range row {
+			m[item.k] += item.n
+		}
+	}
+	for k, n := range done {
+		m[k] -= n
+	}
+	return m
+}
+
+// Fprint writes to w a representation of the progress.
+// The output format can be readed back by ReadProgress.
+func (p *Progress) Fprint(w io.Writer) {
+	fmt.Fprintf(w, "row = %d\n", p.Row+1)
+	fmt.Fprintf(w, "item = %d\n", p.Item+1)
+}
+
+// SaveAs saves the progress to a file.
+func (p *Progress) SaveAs(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	p.Fprint(f)
+	return f.Close()
+}
+
+// ReadProgress reads the progress of the coding from r.
+// The lines are in the form "key = value", with the row
+// and the item 1-based; comments start with "//".
+func ReadProgress(r io.Reader, cod *Coding) (*Progress, error) {
+	p := NewProgress(cod)
+	row, item := 1, 1
+
+	scanner := bufio.NewScanner(r)
+	for num := 1; scanner.Scan(); num++ {
+		l := newSrcLine(scanner.Text(), num)
+		if l.text == "" {
+			continue
+		}
+		idx := strings.IndexRune(l.text, '=')
+		if idx < 0 {
+			return nil, fmt.Errorf("line %d: invalid progress row %q", num, l.text)
+		}
+		key := strings.ToLower(strings.TrimSpace(l.text[:idx]))
+		value, err := strconv.Atoi(l.slice(idx + 1).text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid %s %q", num, key, l.slice(idx+1).text)
+		}
+		switch key {
+		case "row":
+			row = value
+		case "item":
+			item = value
+		default:
+			return nil, fmt.Errorf("line %d: unknown progress field %q", num, key)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := p.Seek(row - 1); err != nil {
+		return nil, err
+	}
+	if !p.Done() && (item < 1 || item > len(cod.prog[p.Row])) {
+		return nil, fmt.Errorf("invalid item #%d of row #%d", item, row)
+	}
+	if !p.Done() {
+		p.Item = item - 1
+	}
+	return p, nil
+}
+
+// LoadProgress loads the progress of the coding from the file at path.
+// If the file does not exist, the progress at the first item is returned.
+func LoadProgress(path string, cod *Coding) (*Progress, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return NewProgress(cod), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	p, err := ReadProgress(f, cod)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return p, nil
+}
+
+// highlight draws a frame around the current row in the image m,
+// where cell returns the pixels of the cell (x, y) of the coding.
+// The current item is underlined. Nothing is drawn outside clip.
+func (p *Progress) highlight(m draw.Image, cell func(x, y int) image.Rectangle, clip image.Rectangle) {
+	if p.Done() {
+		return
+	}
+	row := cell(0, p.Row).Union(cell(p.cod.prog[p.Row].Len()-1, p.Row))
+	src := image.NewUniform(progressHighlight)
+	fill := func(r image.Rectangle) {
+		draw.Draw(m, r.Intersect(clip), src, image.Point{}, draw.Over)
+	}
+	fill(image.Rect(row.Min.X-2, row.Min.Y-2, row.Max.X+2, row.Min.Y))
+	fill(image.Rect(row.Min.X-2, row.Max.Y, row.Max.X+2, row.Max.Y+2))
+	fill(image.Rect(row.Min.X-2, row.Min.Y, row.Min.X, row.Max.Y))
+	fill(image.Rect(row.Max.X, row.Min.Y, row.Max.X+2, row.Max.Y))
+
+	// underline the current item
+	var x int
+	for _, item := range p.cod.prog[p.Row][:p.Item] {
+		x += item.n
+	}
+	n := p.Current().n
+	r := cell(x, p.Row).Union(cell(x+n-1, p.Row))
+	fill(image.Rect(r.Min.X, r.Max.Y-3, r.Max.X, r.Max.Y))
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+)
+
+// YarnOptions are the parameters of the yarn estimate.
+type YarnOptions struct {
+	// StitchSize is the side of a stitch, in cm.
+	StitchSize float64
+	// YarnFactor is the length of yarn used by a stitch,
+	// as a multiple of StitchSize.
+	YarnFactor float64
+	// SkeinLength is the length of yarn of a skein, in meters.
+	SkeinLength float64
+}
+
+// DefaultYarnOptions are the default parameters of the yarn estimate,
+// roughly a single crochet stitch with a medium weight yarn.
+var DefaultYarnOptions = YarnOptions{
+	StitchSize:  0.5,
+	YarnFactor:  6,
+	SkeinLength: 100,
+}
+
+// ColorStats are the statistics of a color of the coding.
+type ColorStats struct {
+	Key     string
+	Cells   int     // number of cells of the color
+	Percent float64 // percentage of the cells of the image
+	Meters  float64 // estimated length of yarn, in meters
+	Skeins  float64 // estimated number of skeins
+}
+
+// Stats are the statistics of a coding.
+type Stats struct {
+	Width, Height int
+	Colors        []ColorStats // in the order of the palette
+	Changes       []int        // number of color changes of each row
+}
+
+// Stats returns the statistics of the coding. If opt is nil,
+// DefaultYarnOptions is used.
+func (cod *Coding) Stats(opt *YarnOptions) *Stats {
+	if opt == nil {
+		opt = &DefaultYarnOptions
+	}
+	st := &Stats{}
+	st.Width, st.Height = cod.Size()
+
+	cells := map[string]int{}
+	for _, row := range cod.prog {
+		for _, item := range row {
+			cells[item.k] += item.n
+		}
+		changes := len(row) - 1
+		if changes < 0 {
+			changes = 0
+		}
+		st.Changes = append(st.Changes, changes)
+	}
+
+	total := st.Width * st.Height
+	for _, k := range cod.pal.i2k {
+		cs := ColorStats{Key: k, Cells: cells[k]}
+		if total > 0 {
+			cs.Percent = 100 * float64(cs.Cells) / float64(total)
+		}
+		cs.Meters = float64(cs.Cells) * opt.StitchSize * opt.YarnFactor / 100
+		if opt.SkeinLength > 0 {
+			cs.Skeins = cs.Meters / opt.SkeinLength
+		}
+		st.Colors = append(st.Colors, cs)
+	}
+	return st
+}
+
+// TotalChanges returns the number of color changes of all the rows.
+func (st *Stats) TotalChanges() int {
+	var n int
+	for _, c := range st.Changes {
+		n += c
+	}
+	return n
+}
+
+// MaxChanges returns the maximum number of color changes of a row,
+// and the row (0-based). It returns -1 if there are no rows.
+func (st *Stats) MaxChanges() (int, int) {
+	max, row := 0, -1
+	for j, c := range st.Changes {
+		if row < 0 || c > max {
+			max, row = c, j
+		}
+	}
+	return max, row
+}
+
+// Fprint writes to w a report of the statistics.
+func (st *Stats) Fprint(w io.Writer) {
+	fmt.Fprintf(w, "size:    %d x %d (%d cells)\n", st.Width, st.Height, st.Width*st.Height)
+	if max, row := st.MaxChanges(); row >= 0 {
+		fmt.Fprintf(w, "changes: %d, at most %d in row #%d\n", st.TotalChanges(), max, row+1)
+	}
+
+	fmt.Fprintf(w, "\n%-12s %8s %7s %8s %7s\n", "color", "cells", "%", "meters", "skeins")
+	for _, cs := range st.Colors {
+		fmt.Fprintf(w, "%-12s %8d %7.1f %8.1f %7d\n",
+			cs.Key, cs.Cells, cs.Percent, cs.Meters, int(math.Ceil(cs.Skeins)))
+	}
+
+	fmt.Fprintf(w, "\n%-5s %7s\n", "row", "changes")
+	for j, c := range st.Changes {
+		fmt.Fprintf(w, "%-5d %7d\n", j+1, c)
+	}
+}
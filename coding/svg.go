@@ -0,0 +1,52 @@
+package main
+
+import (
+	"io"
+	"os"
+
+	codimg "github.com/mmbros/test/coding/image"
+)
+
+// EncodeSvg writes to w the image of the coding in the svg format.
+// Each run of the program is drawn as a rect, with the CSS class
+// of the legend key of its color. The null color is transparent.
+// The cells are displayed cellW pixels wide, and as high as given
+// by the aspect; if aspect is zero, the aspect of the header is used.
+func (cod *Coding) EncodeSvg(w io.Writer, cellW float64, aspect codimg.Aspect) error {
+	classes := make([]codimg.SvgClass, cod.pal.Len())
+	for j, k := range cod.pal.i2k {
+		c, _ := cod.pal.ByKey(k)
+		classes[j] = codimg.SvgClass{Name: k, Color: c}
+	}
+
+	var runs []codimg.SvgRun
+	for y, row := range cod.prog {
+		x := 0
+		for _, item := range row {
+			if idx := cod.pal.Key2Idx(item.k); idx >= 0 {
+				runs = append(runs, codimg.SvgRun{X: x, Y: y, N: item.n, Class: idx})
+			}
+			x += item.n
+		}
+	}
+
+	if aspect == (codimg.Aspect{}) {
+		aspect = cod.hdr.Aspect
+	}
+	dx, dy := cod.Size()
+	return codimg.EncodeScaledSvg(w, dx, dy, cellW, cellW/aspect.Ratio(), classes, runs)
+}
+
+// SaveAsSvg saves the image of the coding in the svg format.
+// See EncodeSvg.
+func (cod *Coding) SaveAsSvg(path string, cellW float64, aspect codimg.Aspect) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = cod.EncodeSvg(f, cellW, aspect)
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	return err
+}
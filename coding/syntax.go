@@ -0,0 +1,393 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// The compact syntax of the program rows.
+//
+// A row is a list of items. Each item is:
+//
+//	3x          a run of 3 cells of color x (the count defaults to 1)
+//	3(2a 1b)    a group of items repeated 3 times: 2a 1b 2a 1b 2a 1b
+//	<1a 2b 5c>  a mirrored group: 1a 2b 5c 2b 1a
+//
+// Groups can be nested. The statement of a row can also define
+// a range of identical rows, or copy previous rows:
+//
+//	5-9 = 2a 3b              rows 5 to 9 are all 2a 3b
+//	10 = same as 4           row 10 is equal to row 4
+//	11-15 = same as 4        rows 11 to 15 are equal to row 4
+//	16-20 = same as 1-5      rows 16 to 20 are equal to rows 1 to 5
+//	21-25 = mirror of 1-5    rows 21 to 25 are equal to rows 5 to 1
+
+// progToken is a token of a program row.
+type progToken struct {
+	s   string // the token
+	off int    // byte offset of the token in the row
+}
+
+// isPunct reports whether ch is a single character token.
+func isPunct(ch rune) bool {
+	return strings.ContainsRune("()<>", ch)
+}
+
+// tokenizeRow splits the program row s in tokens.
+func tokenizeRow(s string) []progToken {
+	var toks []progToken
+	start := -1
+	flush := func(j int) {
+		if start >= 0 {
+			toks = append(toks, progToken{s[start:j], start})
+			start = -1
+		}
+	}
+	for j, ch := range s {
+		switch {
+		case unicode.IsSpace(ch):
+			flush(j)
+		case isPunct(ch):
+			flush(j)
+			toks = append(toks, progToken{string(ch), j})
+		default:
+			if start < 0 {
+				start = j
+			}
+		}
+	}
+	flush(len(s))
+	return toks
+}
+
+// isCount reports whether the token is a count without color.
+func isCount(s string) bool {
+	for _, ch := range s {
+		if ch < '0' || ch > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// canonicalItems returns the canonical representation of the items
+// of a row, in the compact syntax.
+func canonicalItems(s string) string {
+	var b strings.Builder
+	toks := tokenizeRow(s)
+	for j, t := range toks {
+		if j > 0 {
+			prev := toks[j-1].s
+			if prev != "(" && prev != "<" && t.s != ")" && t.s != ">" &&
+				!(t.s == "(" && isCount(prev)) {
+				b.WriteByte(' ')
+			}
+		}
+		if isPunct(rune(t.s[0])) || isCount(t.s) {
+			b.WriteString(t.s)
+		} else {
+			b.WriteString(newProgramItem(t.s).String())
+		}
+	}
+	return b.String()
+}
+
+// The limits of the program: the counts beyond them are certainly
+// mistakes, and could exhaust the memory when expanded.
+const (
+	// maxRowLength is the maximum number of cells of a row.
+	maxRowLength = 10000
+	// maxRows is the maximum number of rows.
+	maxRows = 10000
+)
+
+var (
+	errUnbalanced = errors.New("unbalanced group")
+	errEmptyGroup = errors.New("empty group")
+	errRowLength  = fmt.Errorf("more than %d cells in a row", maxRowLength)
+	errRows       = fmt.Errorf("more than %d rows", maxRows)
+)
+
+// rowParser parses the items of a program row
+// and expands the groups.
+type rowParser struct {
+	l    srcLine
+	toks []progToken
+	pos  int
+	errs ErrorList
+	// tooLong is set when a part of the row has more than
+	// maxRowLength cells: the groups are no more expanded
+	tooLong bool
+}
+
+func (p *rowParser) error(kind ErrorKind, t progToken, err error) {
+	p.errs.Add(p.l.error(kind, t.off, t.s, err))
+}
+
+// group parses the items of a group opened by the token open,
+// and consumes the closing token.
+func (p *rowParser) group(open progToken, close string) []*ProgramItem {
+	items := p.items(close)
+	if p.pos < len(p.toks) && p.toks[p.pos].s == close {
+		p.pos++
+	} else {
+		p.error(InvalidGroup, open, errUnbalanced)
+	}
+	if len(items) == 0 {
+		p.error(InvalidGroup, open, errEmptyGroup)
+	}
+	return items
+}
+
+// items parses the items up to the closing token close,
+// or up to the end of the row if close is empty.
+func (p *rowParser) items(close string) []*ProgramItem {
+	var items []*ProgramItem
+	// the cells of the items
+	var cells int
+
+	// add appends the items returned by expand, if they fit in the
+	// room left in the row: expand returns false if they do not
+	add := func(t progToken, expand func(room int) ([]*ProgramItem, bool)) {
+		if p.tooLong {
+			return
+		}
+		a, ok := expand(maxRowLength - cells)
+		if !ok {
+			p.tooLong = true
+			p.error(InvalidItem, t, errRowLength)
+			return
+		}
+		items = append(items, a...)
+		cells += ProgramRow(a).Len()
+	}
+
+	for p.pos < len(p.toks) {
+		t := p.toks[p.pos]
+
+		switch t.s {
+		case ")", ">":
+			if t.s == close {
+				return items
+			}
+			p.error(InvalidGroup, t, errUnbalanced)
+			p.pos++
+
+		case "(":
+			p.pos++
+			group := p.group(t, ")")
+			add(t, func(room int) ([]*ProgramItem, bool) {
+				return group, ProgramRow(group).Len() <= room
+			})
+
+		case "<":
+			p.pos++
+			group := p.group(t, ">")
+			add(t, func(room int) ([]*ProgramItem, bool) {
+				return mirrorItems(group, room)
+			})
+
+		default:
+			p.pos++
+			if isCount(t.s) && p.pos < len(p.toks) && p.toks[p.pos].s == "(" {
+				// repeated group
+				open := p.toks[p.pos]
+				p.pos++
+				group := p.group(open, ")")
+				// a count out of the int range is the max int
+				n, _ := strconv.Atoi(t.s)
+				if n == 0 {
+					p.error(InvalidItem, t, nil)
+					continue
+				}
+				add(t, func(room int) ([]*ProgramItem, bool) {
+					if n > room || n*ProgramRow(group).Len() > room {
+						return nil, false
+					}
+					var a []*ProgramItem
+					for j := 0; j < n; j++ {
+						a = append(a, copyItems(group)...)
+					}
+					return a, true
+				})
+				continue
+			}
+
+			pi := newProgramItem(t.s)
+			pi.line = p.l.num
+			pi.col = p.l.off + t.off + 1
+			if pi.n == 0 {
+				p.error(InvalidItem, t, nil)
+				continue
+			}
+			if pi.k == "" {
+				p.error(MissingColor, t, nil)
+			}
+			add(t, func(room int) ([]*ProgramItem, bool) {
+				return []*ProgramItem{pi}, pi.n <= room
+			})
+		}
+	}
+	return items
+}
+
+// parseItems parses the items of the row in l,
+// returning the expanded items.
+func parseItems(l srcLine) (ProgramRow, error) {
+	p := &rowParser{l: l, toks: tokenizeRow(l.text)}
+	items := p.items("")
+	if len(p.toks) == 0 {
+		p.errs.Add(l.error(EmptyRow, 0, "", nil))
+	}
+	if len(p.errs) > 0 {
+		return nil, p.errs.Err()
+	}
+	return mergeItems(items), nil
+}
+
+// copyItems returns a copy of the items.
+func copyItems(items []*ProgramItem) []*ProgramItem {
+	a := make([]*ProgramItem, len(items))
+	for j, pi := range items {
+		c := *pi
+		a[j] = &c
+	}
+	return a
+}
+
+// mirrorItems returns the items followed by the reversed items,
+// the last item being the center of the mirror. It returns false,
+// without expanding the items, if they would be more than max cells:
+// the nested mirrors double the cells at each level.
+func mirrorItems(items []*ProgramItem, max int) ([]*ProgramItem, bool) {
+	n := ProgramRow(items).Len()
+	if len(items) > 0 && 2*n-items[len(items)-1].n > max {
+		return nil, false
+	}
+	a := copyItems(items)
+	for j := len(items) - 2; j >= 0; j-- {
+		c := *items[j]
+		a = append(a, &c)
+	}
+	return a, true
+}
+
+// mergeItems merges the adjacent items of the same color.
+func mergeItems(items []*ProgramItem) ProgramRow {
+	r := ProgramRow{}
+	for _, pi := range items {
+		if n := len(r); n > 0 && r[n-1].k == pi.k {
+			c := *r[n-1]
+			c.n += pi.n
+			r[n-1] = &c
+			continue
+		}
+		r = append(r, pi)
+	}
+	return r
+}
+
+// programStmt is a statement of the program: a range of rows
+// defined by items, or copied (or mirrored) from previous rows.
+type programStmt struct {
+	first, last int     // range of the rows, 1-based
+	ref, refEnd int     // range of the rows copied by the statement, or 0
+	mirror      bool    // the copied rows are taken in reverse order
+	refTok      srcLine // the rows copied, as in the source
+	items       srcLine // the items of the rows
+}
+
+var reCopy = regexp.MustCompile(`(?i)^(same\s+as|mirror\s+of)\s+(\S+)$`)
+
+// parseRange parses a range of rows in the form "N" or "N-M".
+// The numbers out of the int range are returned as the max int.
+func parseRange(s string) (int, int, bool) {
+	first, last := s, s
+	if j := strings.IndexRune(s, '-'); j >= 0 {
+		first = strings.TrimSpace(s[:j])
+		last = strings.TrimSpace(s[j+1:])
+	}
+	n, err1 := atoi(first)
+	m, err2 := atoi(last)
+	return n, m, err1 == nil && err2 == nil
+}
+
+// atoi is like strconv.Atoi, but the numbers out of the int range
+// are not an error: the max (or min) int is returned.
+func atoi(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if errors.Is(err, strconv.ErrRange) {
+		err = nil
+	}
+	return n, err
+}
+
+// parseRowProgram parses the program row in l. The first row
+// of the statement must follow prevRowNum. On error, the returned
+// statement has the range of the rows, if known.
+func parseRowProgram(l srcLine, prevRowNum int) (programStmt, error) {
+	var stmt programStmt
+	var ok bool
+	s := l.text
+
+	idx := strings.IndexRune(s, '=')
+	if idx < 0 {
+		return stmt, l.error(InvalidProgramRow, 0, s, nil)
+	}
+
+	token := strings.TrimSpace(s[0:idx])
+	stmt.first, stmt.last, ok = parseRange(token)
+	if !ok {
+		return programStmt{}, l.error(InvalidProgramRow, 0, s, nil)
+	}
+	if stmt.last < stmt.first {
+		return programStmt{}, l.error(InvalidRowNumber, 0, token, errors.New("invalid range"))
+	}
+	if stmt.last > maxRows {
+		return programStmt{}, l.error(InvalidRowNumber, 0, token, errRows)
+	}
+
+	stmt.items = l.slice(idx + 1)
+	if m := reCopy.FindStringSubmatch(stmt.items.text); m != nil {
+		stmt.mirror = strings.HasPrefix(strings.ToLower(m[1]), "mirror")
+		stmt.refTok = stmt.items.slice(len(stmt.items.text) - len(m[2]))
+		stmt.ref, stmt.refEnd, ok = parseRange(m[2])
+		n := stmt.refEnd - stmt.ref + 1
+		if !ok || stmt.ref < 1 || stmt.refEnd >= stmt.first || n < 1 ||
+			(n > 1 && n != stmt.last-stmt.first+1) {
+			return stmt, stmt.refTok.error(InvalidReference, 0, m[2], nil)
+		}
+	}
+
+	if stmt.first != prevRowNum+1 {
+		err := fmt.Errorf("expecting row #%d of the program, found row #%d", prevRowNum+1, stmt.first)
+		return stmt, l.error(InvalidRowNumber, 0, token, err)
+	}
+
+	return stmt, nil
+}
+
+// rowRange returns the representation of the range of rows
+// from first to last (0-based).
+func rowRange(first, last int) string {
+	if first == last {
+		return strconv.Itoa(first + 1)
+	}
+	return fmt.Sprintf("%d-%d", first+1, last+1)
+}
+
+// String returns the canonical representation of the statement.
+func (stmt programStmt) String() string {
+	s := rowRange(stmt.first-1, stmt.last-1)
+	switch {
+	case stmt.mirror:
+		return fmt.Sprintf("%s = mirror of %s", s, rowRange(stmt.ref-1, stmt.refEnd-1))
+	case stmt.ref > 0:
+		return fmt.Sprintf("%s = same as %s", s, rowRange(stmt.ref-1, stmt.refEnd-1))
+	}
+	return s + " = " + canonicalItems(stmt.items.text)
+}
@@ -1,107 +1,20 @@
 package main
 
 import (
-	"errors"
+	"flag"
 	"fmt"
 	"image"
-	"log"
-	"os"
-	"sort"
-
-	// Package image/jpeg is not used explicitly in the code below,
-	// but is imported for its initialization side-effect, which allows
-	// image.Decode to understand JPEG formatted images. Uncomment these
-	// two lines to also understand GIF and PNG images:
-	// _ "image/gif"
-	// _ "image/png"
 	"image/color"
-	_ "image/jpeg"
 	"image/png"
+	"log"
+	"os"
+	"strings"
 
-	"github.com/Nykakin/quantize"
-	"github.com/RobCherry/vibrant"
-	"golang.org/x/image/draw"
+	codimg "github.com/mmbros/test/coding/image"
 )
 
 //"golang.org/x/image/draw"
 
-// AverageImageColor is  ...
-//  https://jimsaunders.net/2015/05/22/manipulating-colors-in-go.html
-func AverageImageColor(i image.Image) color.Color {
-	var r, g, b uint32
-
-	bounds := i.Bounds()
-
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			pr, pg, pb, _ := i.At(x, y).RGBA()
-			r += pr
-			g += pg
-			b += pb
-		}
-	}
-
-	d := uint32(bounds.Dy() * bounds.Dx() * 0x101)
-
-	r /= d
-	g /= d
-	b /= d
-
-	return color.NRGBA{uint8(r), uint8(g), uint8(b), 255}
-}
-
-type colorSamplerFunc func(m image.Image, x, y int) color.Color
-
-func colorAt(m image.Image, x, y int) color.Color {
-	return m.At(x, y)
-}
-
-func colorAverageFactory(w, h int) colorSamplerFunc {
-	if w == 1 && h == 1 {
-		return colorAt
-	}
-
-	fn := func(m image.Image, x, y int) color.Color {
-		var si image.Image
-
-		dx, dy := w/2, h/2
-		r := image.Rect(x-dx, y-dy, x+dx, y+dy)
-
-		switch i := m.(type) {
-		case *image.Alpha:
-			si = i.SubImage(r)
-		case *image.Alpha16:
-			si = i.SubImage(r)
-		case *image.RGBA:
-			si = i.SubImage(r)
-		case *image.NRGBA:
-			si = i.SubImage(r)
-		case *image.YCbCr:
-			si = i.SubImage(r)
-		default:
-			log.Fatal("Invalid image type")
-		}
-		return AverageImageColor(si)
-
-	}
-
-	return fn
-}
-
-func loadImage(path string) (image.Image, error) {
-	reader, err := os.Open(path)
-	if err != nil {
-		return nil, err
-	}
-	defer reader.Close()
-	m, imageType, err := image.Decode(reader)
-	if err != nil {
-		return nil, err
-	}
-	fmt.Printf("image-type = %s\n", imageType)
-	return m, nil
-}
-
 func saveImagePng(m image.Image, path string) error {
 
 	// outputFile is a File type which satisfies Writer interface
@@ -119,50 +32,24 @@ func saveImagePng(m image.Image, path string) error {
 	return nil
 }
 
-func downsize(m image.Image, sampler colorSamplerFunc, pixelx, pixely int) (image.Image, error) {
-	bounds := m.Bounds()
-	Dx := bounds.Dx()
-	Dy := bounds.Dy()
-	if Dx < pixelx {
-		return nil, errors.New("Can't downsize: destination width bigger that source image width")
-	}
-	if Dy < pixely {
-		return nil, errors.New("Can't downsize: destination height bigger that source image height")
-	}
+// sampler computes the color of the cells of the pixelated image.
+var sampler codimg.Sampler = codimg.SampleNearest
 
-	g := image.NewNRGBA(image.Rect(0, 0, pixelx, pixely))
-	fmt.Printf("rect.DX = %d\nrect.DY = %d\n", g.Bounds().Dx(), g.Bounds().Dy())
+// dither is the strategy used to map the colors to the palette.
+var dither = codimg.DitherNone
 
-	sx := float32(Dx) / float32(pixelx)
-	sy := float32(Dy) / float32(pixely)
-
-	fmt.Printf("Dx=%d, dx=%d, sx=%f\n", Dx, pixelx, sx)
-	fmt.Printf("Dy=%d, dy=%d, sy=%f\n", Dy, pixely, sy)
-
-	ry := sy / 2
-	for y := 0; y < pixely; y++ {
-
-		rx := sx / 2
-		for x := 0; x < pixelx; x++ {
-
-			c := sampler(m, int(rx+0.5), int(ry+0.5))
-			g.Set(x, y, c)
-			//	fmt.Printf("[%d,%d] = %v\n", x, y, c)
-
-			rx += sx
-		}
-		ry += sy
-	}
+// juvePalette is the palette of the colors of juve.jpg.
+var juvePalette = codimg.FixedPalette{
+	color.RGBA{R: 0, G: 0, B: 9, A: 255},
+	color.RGBA{R: 155, G: 155, B: 155, A: 255},
+	color.RGBA{R: 180, G: 160, B: 63, A: 255},
+}
 
-	return g, nil
+// extractor extracts the palette of juve.jpg.
+var extractor codimg.PaletteExtractor = juvePalette
 
-}
 func palettedImage(m image.Image, pal color.Palette) *image.Paletted {
-	bounds := m.Bounds()
-	palImg := image.NewPaletted(bounds, pal)
-	draw.Draw(palImg, palImg.Rect, m, bounds.Min, draw.Over)
-
-	return palImg
+	return codimg.Quantize(m, pal, dither)
 }
 func palettedImageiOLD(m image.Image, pal color.Palette) image.Image {
 	bounds := m.Bounds()
@@ -175,92 +62,19 @@ func palettedImageiOLD(m image.Image, pal color.Palette) image.Image {
 	}
 	return i
 }
-func upsize(m image.Image, mx, my int) (image.Image, error) {
-
-	bounds := m.Bounds()
-	Dx := bounds.Dx()
-	Dy := bounds.Dy()
-
-	g := image.NewNRGBA(image.Rect(0, 0, Dx*mx, Dy*my))
-
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-
-			c := m.At(x, y)
-			xx := mx * (x - bounds.Min.X)
-			yy := my * (y - bounds.Min.Y)
-			for iy := 0; iy < my; iy++ {
-				for ix := 0; ix < mx; ix++ {
-					g.Set(xx+ix, yy+iy, c)
-				}
-			}
-
-		}
-	}
-	return g, nil
-}
-
-func getPal2(img image.Image, maximumColorCount int) color.Palette {
-	quantizer := quantize.NewHierarhicalQuantizer()
-	colors, err := quantizer.Quantize(img, maximumColorCount)
-	if err != nil {
-		panic(err)
-	}
-
-	palette := make([]color.Color, len(colors))
-	for index, clr := range colors {
-		palette[index] = clr
-	}
-	return palette
-}
-
-func getPal(i image.Image, maximumColorCount int) color.Palette {
-	paletteBuilder := vibrant.NewPaletteBuilder(i).
-		ClearFilters().
-		ClearTargets().
-		ClearRegion().
-		MaximumColorCount(uint32(maximumColorCount)).
-		Scaler(draw.ApproxBiLinear)
-
-	palette := paletteBuilder.Generate()
-
-	swatches := palette.Swatches()
-	sort.Sort(populationSwatchSorter(swatches))
-	colorPalette := make(color.Palette, 0, len(swatches))
-	for _, swatch := range swatches {
-		colorPalette = append(colorPalette, swatch.Color())
-	}
-	return colorPalette
-
-}
-
-type populationSwatchSorter []*vibrant.Swatch
-
-func (p populationSwatchSorter) Len() int           { return len(p) }
-func (p populationSwatchSorter) Less(i, j int) bool { return p[i].Population() > p[j].Population() }
-func (p populationSwatchSorter) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
-
-type hueSwatchSorter []*vibrant.Swatch
-
-func (p hueSwatchSorter) Len() int           { return len(p) }
-func (p hueSwatchSorter) Less(i, j int) bool { return p[i].HSL().H < p[j].HSL().H }
-func (p hueSwatchSorter) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
-
 func pokemon() {
 	input := "pokemon.jpg"
 
-	m, err := loadImage(input)
+	m, _, err := codimg.Load(input)
 	if err != nil {
 		log.Fatal(err)
 	}
-	//fn := colorAt
-	fn := colorAverageFactory(3, 3)
-	mm, err := downsize(m, fn, 41, 38)
+	mm, err := codimg.Pixelate(m, codimg.SampleBox, 41, 38)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	mm2, err := upsize(mm, 16, 16)
+	mm2, err := codimg.Zoom(mm, 16, 16)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -270,13 +84,16 @@ func pokemon() {
 		log.Fatal(err)
 	}
 
-	pal := getPal(mm, 8)
+	pal, err := codimg.VibrantExtractor{}.Extract(mm, 8)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	imgpal := palettedImage(mm, pal)
 
 	saveCoding("coding.txt", imgpal)
 
-	mm3, err := upsize(imgpal, 16, 16)
+	mm3, err := codimg.Zoom(imgpal, 16, 16)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -333,21 +150,48 @@ func saveCoding(path string, imgpal *image.Paletted) error {
 	return nil
 }
 func main() {
+	ditherName := flag.String("dither", "none", "dithering: none, floyd-steinberg, atkinson, bayer2, bayer4 or bayer8")
+	paletteName := flag.String("palette", "juve", "palette extractor: juve, "+strings.Join(codimg.ExtractorNames, ", "))
+	samplerName := flag.String("sampler", "nearest", "color of a cell: "+strings.Join(codimg.SamplerNames, ", "))
+	cols := flag.Int("cols", 26, "number of columns")
+	rows := flag.Int("rows", 43, "number of rows (0 = keep the proportions of the image)")
+	aspectRatio := flag.String("aspect", "1:1", "ratio width:height of a cell")
+	flag.Parse()
+	aspect, err := codimg.ParseAspect(*aspectRatio)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if sampler, err = codimg.NewSampler(*samplerName); err != nil {
+		log.Fatal(err)
+	}
+	d, err := codimg.ParseDither(*ditherName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	dither = d
+	if *paletteName != "juve" {
+		if extractor, err = codimg.NewExtractor(*paletteName); err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	input := "juve.jpg"
-	pixelX, pixelY := 26, 43
 
-	m, err := loadImage(input)
+	m, _, err := codimg.Load(input)
 	if err != nil {
 		log.Fatal(err)
 	}
-	//fn := colorAt
-	fn := colorAverageFactory(1, 1)
-	mm, err := downsize(m, fn, pixelX, pixelY)
+	pixelX, pixelY := *cols, *rows
+	if pixelY <= 0 {
+		pixelX, pixelY = codimg.GridSize(m.Bounds(), *cols, aspect)
+	}
+	cellX, cellY := aspect.CellSize(16)
+	mm, err := codimg.Pixelate(m, sampler, pixelX, pixelY)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	mm2, err := upsize(mm, 16, 16)
+	mm2, err := codimg.Zoom(mm, cellX, cellY)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -357,18 +201,16 @@ func main() {
 		log.Fatal(err)
 	}
 
-	//pal := getPal(mm, 3)
-	pal := color.Palette{
-		color.RGBA{R: 0, G: 0, B: 9, A: 255},
-		color.RGBA{R: 155, G: 155, B: 155, A: 255},
-		color.RGBA{R: 180, G: 160, B: 63, A: 255},
+	pal, err := extractor.Extract(mm, 3)
+	if err != nil {
+		log.Fatal(err)
 	}
 
 	imgpal := palettedImage(mm, pal)
 
 	saveCoding("coding-juve.txt", imgpal)
 
-	mm3, err := upsize(imgpal, 16, 16)
+	mm3, err := codimg.Zoom(imgpal, cellX, cellY)
 	if err != nil {
 		log.Fatal(err)
 	}